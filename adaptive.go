@@ -0,0 +1,240 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialLimit = 20
+	defaultMinLimit     = 1
+	defaultMaxLimit     = 1000
+	defaultBackoffRatio = 0.9
+	defaultTolerance    = 1.5
+	defaultSmoothing    = 0.2
+	longRTTWindow       = 600
+)
+
+// LimitAlgorithm - how an AdaptiveLimiter moves its limit.
+type LimitAlgorithm int
+
+const (
+	// AIMD - additive increase, multiplicative decrease.  The limit grows
+	// by one for each success while at least half of it is in use, and is
+	// cut by BackoffRatio on every overloaded response, or one slower than
+	// LatencyThreshold.
+	AIMD LimitAlgorithm = iota
+
+	// Gradient - follows the ratio of the long term average latency to
+	// the current one, shrinking the limit as soon as latency rises above
+	// Tolerance times the average, before the backend starts failing.
+	// Overloaded responses still cut it by BackoffRatio.
+	Gradient
+)
+
+func (a LimitAlgorithm) String() string {
+	switch a {
+	case AIMD:
+		return "aimd"
+	case Gradient:
+		return "gradient"
+	}
+	return fmt.Sprintf("LimitAlgorithm(%d)", int(a))
+}
+
+// AdaptiveLimiter - a Limiter whose concurrency limit follows the latency
+// and errors observed, rather than being fixed.  Requests over the current
+// limit are rejected straight away with a *RejectedError, shedding load
+// while the backend is struggling.
+type AdaptiveLimiter struct {
+	// Algorithm - AIMD (default) or Gradient.
+	Algorithm LimitAlgorithm
+
+	// InitialLimit, MinLimit, MaxLimit - where the limit starts and the
+	// range it moves in.  Zero means 20, 1 and 1000.
+	InitialLimit, MinLimit, MaxLimit int
+
+	// BackoffRatio - what the limit is multiplied by on overload.  Zero
+	// means 0.9.
+	BackoffRatio float64
+
+	// LatencyThreshold - for AIMD, responses slower than this count as
+	// overload.  Zero means only errors do.
+	LatencyThreshold time.Duration
+
+	// Tolerance - for Gradient, how far above the long term average
+	// latency may rise before the limit shrinks.  Zero means 1.5.
+	Tolerance float64
+
+	// PerHost - setting this to true keeps a separate limit for each
+	// request host.
+	PerHost bool
+
+	// OnLimitChange - when set, called whenever the whole number limit for
+	// a key changes, for exporting it to metrics.  It is called with the
+	// limiter's lock held, so must not call back into it.
+	OnLimitChange func(key string, limit int)
+
+	mu     sync.Mutex
+	states map[string]*adaptiveState
+}
+
+// AdaptiveStats - a snapshot of one key of an AdaptiveLimiter.
+type AdaptiveStats struct {
+	Limit    int
+	InFlight int
+	Rejected uint64
+
+	// LongLatency - the long term average latency, for Gradient.
+	LongLatency time.Duration
+}
+
+type adaptiveState struct {
+	limit    float64
+	inFlight int
+	rejected uint64
+	longRTT  float64
+	samples  int
+}
+
+// Acquire - implements Limiter.
+func (al *AdaptiveLimiter) Acquire(req *http.Request) (func(Outcome), error) {
+	key := ""
+	if al.PerHost {
+		key = req.URL.Host
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	st := al.state(key)
+	limit := int(st.limit)
+	if st.inFlight >= limit {
+		st.rejected++
+		return nil, &RejectedError{Key: key, Reason: "concurrency limit reached", Limit: limit}
+	}
+	st.inFlight++
+
+	var once sync.Once
+	return func(o Outcome) {
+		once.Do(func() {
+			al.mu.Lock()
+			defer al.mu.Unlock()
+			inFlight := st.inFlight
+			st.inFlight--
+			if o.Err != nil && errors.Is(o.Err, context.Canceled) {
+				// Says nothing about the backend.
+				return
+			}
+			before := int(st.limit)
+			al.update(st, o, inFlight)
+			if after := int(st.limit); after != before && al.OnLimitChange != nil {
+				al.OnLimitChange(key, after)
+			}
+		})
+	}, nil
+}
+
+// Limit - returns the current limit for key, the request host when
+// PerHost is set and "" otherwise.
+func (al *AdaptiveLimiter) Limit(key string) int {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return int(al.state(key).limit)
+}
+
+// Stats - returns a snapshot of every key seen so far.
+func (al *AdaptiveLimiter) Stats() map[string]AdaptiveStats {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	out := make(map[string]AdaptiveStats, len(al.states))
+	for k, st := range al.states {
+		out[k] = AdaptiveStats{
+			Limit:       int(st.limit),
+			InFlight:    st.inFlight,
+			Rejected:    st.rejected,
+			LongLatency: time.Duration(st.longRTT),
+		}
+	}
+	return out
+}
+
+func (al *AdaptiveLimiter) state(key string) *adaptiveState {
+	if al.states == nil {
+		al.states = make(map[string]*adaptiveState)
+	}
+	st, ok := al.states[key]
+	if !ok {
+		initial := al.InitialLimit
+		if initial <= 0 {
+			initial = defaultInitialLimit
+		}
+		st = &adaptiveState{limit: al.clamp(float64(initial))}
+		al.states[key] = st
+	}
+	return st
+}
+
+// update - moves the limit after an admitted request finished with o,
+// while inFlight requests, including itself, were outstanding.
+func (al *AdaptiveLimiter) update(st *adaptiveState, o Outcome, inFlight int) {
+	backoff := al.BackoffRatio
+	if backoff <= 0 || backoff >= 1 {
+		backoff = defaultBackoffRatio
+	}
+	if o.Overloaded() {
+		st.limit = al.clamp(st.limit * backoff)
+		return
+	}
+	if o.Err != nil {
+		return
+	}
+
+	rtt := float64(o.Latency)
+	switch al.Algorithm {
+	case Gradient:
+		// The long term average adapts slowly, so a rise in latency shows
+		// up as a gradient below 1.
+		st.samples++
+		window := float64(st.samples)
+		if window > longRTTWindow {
+			window = longRTTWindow
+		}
+		st.longRTT += (rtt - st.longRTT) / window
+		tolerance := al.Tolerance
+		if tolerance <= 0 {
+			tolerance = defaultTolerance
+		}
+		gradient := 1.0
+		if rtt > 0 {
+			gradient = math.Max(0.5, math.Min(1, tolerance*st.longRTT/rtt))
+		}
+		// Leave headroom so the limit can probe upwards while latency
+		// holds steady.
+		target := st.limit*gradient + math.Sqrt(st.limit)
+		st.limit = al.clamp(st.limit*(1-defaultSmoothing) + target*defaultSmoothing)
+
+	default:
+		if al.LatencyThreshold > 0 && o.Latency > al.LatencyThreshold {
+			st.limit = al.clamp(st.limit * backoff)
+			return
+		}
+		if float64(inFlight)*2 >= st.limit {
+			st.limit = al.clamp(st.limit + 1)
+		}
+	}
+}
+
+func (al *AdaptiveLimiter) clamp(limit float64) float64 {
+	lo, hi := al.MinLimit, al.MaxLimit
+	if lo <= 0 {
+		lo = defaultMinLimit
+	}
+	if hi <= 0 {
+		hi = defaultMaxLimit
+	}
+	return math.Max(float64(lo), math.Min(float64(hi), limit))
+}
@@ -0,0 +1,109 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	var changes []int
+	al := &AdaptiveLimiter{
+		InitialLimit:     4,
+		LatencyThreshold: 100 * time.Millisecond,
+		OnLimitChange:    func(key string, limit int) { changes = append(changes, limit) },
+	}
+	req, _ := http.NewRequest("GET", "http://a.example.com/", nil)
+
+	var releases []func(Outcome)
+	for i := 0; i < 4; i++ {
+		release, err := al.Acquire(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	_, err := al.Acquire(req)
+	var re *RejectedError
+	if !errors.As(err, &re) || re.Limit != 4 {
+		t.Fatal("expected rejection at the limit, got ", err)
+	}
+
+	releases[0](Outcome{Latency: time.Millisecond, StatusCode: 200})
+	if al.Limit("") != 5 {
+		t.Log("expected additive increase, got ", al.Limit(""))
+		t.Fail()
+	}
+	releases[1](Outcome{Latency: time.Millisecond, StatusCode: 503})
+	releases[2](Outcome{Latency: time.Second, StatusCode: 200})
+	if al.Limit("") != 4 {
+		t.Log("expected multiplicative decrease, got ", al.Limit(""))
+		t.Fail()
+	}
+	releases[3](Outcome{Err: context.Canceled})
+	st := al.Stats()[""]
+	if st.InFlight != 0 || st.Rejected != 1 || st.Limit != 4 {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+	if len(changes) != 2 || changes[0] != 5 || changes[1] != 4 {
+		t.Log("unexpected limit changes ", changes)
+		t.Fail()
+	}
+}
+
+func TestAdaptiveLimiterGradient(t *testing.T) {
+	al := &AdaptiveLimiter{Algorithm: Gradient, InitialLimit: 10, MaxLimit: 50, PerHost: true}
+	req, _ := http.NewRequest("GET", "http://a.example.com/", nil)
+	run := func(n int, latency time.Duration) {
+		for i := 0; i < n; i++ {
+			release, err := al.Acquire(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release(Outcome{Latency: latency, StatusCode: 200})
+		}
+	}
+
+	run(100, 10*time.Millisecond)
+	steady := al.Limit("a.example.com")
+	if steady <= 10 {
+		t.Log("expected the limit to grow while latency is steady, got ", steady)
+		t.Fail()
+	}
+	run(20, 100*time.Millisecond)
+	if got := al.Limit("a.example.com"); got >= steady {
+		t.Logf("expected the limit to shrink as latency rises, got %d from %d", got, steady)
+		t.Fail()
+	}
+	if al.Limit("b.example.com") != 10 {
+		t.Log("expected a separate limit per host")
+		t.Fail()
+	}
+}
+
+func TestAdaptiveLimiterClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := &AdaptiveLimiter{InitialLimit: 10}
+	cl.Limiter = al
+	for i := 0; i < 5; i++ {
+		cl.Get(context.Background(), su, "/", nil, nil)
+	}
+	if got := al.Limit(""); got != 5 {
+		t.Log("expected 503s to shrink the limit, got ", got)
+		t.Fail()
+	}
+}
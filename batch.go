@@ -0,0 +1,161 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultBatchParallelism = 8
+
+// ErrSkipped - the error of batch items that were never sent, because the
+// batch was stopped early.
+var ErrSkipped = errors.New("restclient: batch item skipped")
+
+// BatchItem - one request in a batch, with the arguments of
+// ReqWithHeaders.
+type BatchItem struct {
+	Method       string
+	Path         string
+	QueryStruct  interface{}
+	RequestBody  interface{}
+	ResponseBody interface{}
+	Headers      http.Header
+}
+
+// BatchResult - the outcome of one BatchItem.
+type BatchResult struct {
+	Resp *http.Response
+	Err  error
+}
+
+// BatchProgress - how far a batch has got.
+type BatchProgress struct {
+	Total     int
+	Completed int
+	Failed    int
+}
+
+// BatchOptions - controls BaseClient.Batch.
+type BatchOptions struct {
+	// Parallelism - the most requests in flight at once.  Zero means 8.
+	Parallelism int
+
+	// Rate - the most requests started per second, spread evenly.  Zero
+	// means no limit.
+	Rate float64
+
+	// FailFast - stop at the first failure, cancelling the requests in
+	// flight and skipping the rest.  By default the batch carries on.
+	FailFast bool
+
+	// OnProgress - when set, called after each item completes.  Calls are
+	// never concurrent.
+	OnProgress func(BatchProgress)
+}
+
+// BatchError - returned by BaseClient.Batch when any item failed.
+type BatchError struct {
+	// Failed, Total - how many items failed, skipped ones included, out of
+	// how many.
+	Failed, Total int
+
+	// First - the error of the first item to fail.
+	First error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d batch requests failed, first: %v", e.Failed, e.Total, e.First)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.First
+}
+
+// Batch - sends every item through ReqWithHeaders, with bounded
+// parallelism and an optional rate limit, and returns their results in
+// the same order as items.  The error is a *BatchError if any item failed,
+// or was skipped because of FailFast or ctx ending.
+func (bc *BaseClient) Batch(ctx context.Context, items []BatchItem, opts *BatchOptions) ([]BatchResult, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultBatchParallelism
+	}
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]BatchResult, len(items))
+	progress := BatchProgress{Total: len(items)}
+	var (
+		mu    sync.Mutex
+		first = -1
+		wg    sync.WaitGroup
+	)
+	finish := func(i int, res BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = res
+		progress.Completed++
+		if res.Err != nil {
+			progress.Failed++
+			if first < 0 {
+				first = i
+			}
+			if opts.FailFast {
+				cancel()
+			}
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+	}
+
+	sem := make(chan struct{}, parallelism)
+	next := time.Now()
+	for i := range items {
+		// Wait for a free slot, then for the rate limit, so the pacing
+		// isn't used up while blocked on parallelism.
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() == nil && interval > 0 {
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+			sleepContext(ctx, time.Until(next))
+			next = next.Add(interval)
+		}
+		if ctx.Err() != nil {
+			for j := i; j < len(items); j++ {
+				finish(j, BatchResult{Err: ErrSkipped})
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, item BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := bc.ReqWithHeaders(ctx, item.Method, item.Path, item.QueryStruct,
+				item.RequestBody, item.ResponseBody, item.Headers)
+			finish(i, BatchResult{Resp: resp, Err: err})
+		}(i, items[i])
+	}
+	wg.Wait()
+
+	if progress.Failed == 0 {
+		return results, nil
+	}
+	return results, &BatchError{Failed: progress.Failed, Total: len(items), First: results[first].Err}
+}
@@ -0,0 +1,135 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newBatchServer(t *testing.T) (*BaseClient, func() int, func()) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/bad") {
+			w.WriteHeader(400)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"foo":%q}`, r.URL.Path)
+	}))
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &BaseClient{Client: cl, BaseURL: su}, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return peak
+	}, srv.Close
+}
+
+func TestBatch(t *testing.T) {
+	bc, peak, done := newBatchServer(t)
+	defer done()
+
+	ttl := 600
+	out := make([]testResponse, 20)
+	items := make([]BatchItem, len(out))
+	for i := range items {
+		path := fmt.Sprintf("/v1/domains/catpics.org/records/A/%d", i)
+		if i == 7 {
+			path += "/bad"
+		}
+		items[i] = BatchItem{
+			Method:       "PUT",
+			Path:         path,
+			RequestBody:  DNSRecords{{Type: "A", Name: "catpics.org", Data: "1", TTL: &ttl}},
+			ResponseBody: &out[i],
+		}
+	}
+	var reports []BatchProgress
+	results, err := bc.Batch(context.Background(), items, &BatchOptions{
+		Parallelism: 3,
+		OnProgress:  func(p BatchProgress) { reports = append(reports, p) },
+	})
+	var be *BatchError
+	var re *ResponseError
+	if !errors.As(err, &be) || be.Failed != 1 || be.Total != 20 || !errors.As(err, &re) || re.StatusCode != 400 {
+		t.Fatal("expected one failure, got ", err)
+	}
+	for i, res := range results {
+		if (res.Err != nil) != (i == 7) || (i != 7 && out[i].Foo != items[i].Path) {
+			t.Log("unexpected result ", i, res.Err, out[i])
+			t.Fail()
+		}
+	}
+	if p := peak(); p > 3 || p < 2 {
+		t.Log("expected parallelism of 3, got ", p)
+		t.Fail()
+	}
+	if len(reports) != 20 || reports[19] != (BatchProgress{Total: 20, Completed: 20, Failed: 1}) {
+		t.Logf("unexpected progress %v", reports)
+		t.Fail()
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	bc, _, done := newBatchServer(t)
+	defer done()
+
+	items := []BatchItem{{Method: "GET", Path: "/bad"}}
+	for i := 0; i < 10; i++ {
+		items = append(items, BatchItem{Method: "GET", Path: fmt.Sprintf("/%d", i)})
+	}
+	results, err := bc.Batch(context.Background(), items, &BatchOptions{Parallelism: 2, FailFast: true})
+	var re *ResponseError
+	if !errors.As(err, &re) || re.StatusCode != 400 {
+		t.Fatal("expected the first failure to be reported, got ", err)
+	}
+	if !errors.Is(results[len(results)-1].Err, ErrSkipped) {
+		t.Log("expected the last item to be skipped, got ", results[len(results)-1].Err)
+		t.Fail()
+	}
+}
+
+func TestBatchRate(t *testing.T) {
+	bc, _, done := newBatchServer(t)
+	defer done()
+
+	items := make([]BatchItem, 6)
+	for i := range items {
+		items[i] = BatchItem{Method: "GET", Path: "/"}
+	}
+	start := time.Now()
+	if _, err := bc.Batch(context.Background(), items, &BatchOptions{Rate: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Log("expected the rate limit to space out requests, took ", d)
+		t.Fail()
+	}
+}
@@ -0,0 +1,222 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type requestOptionsKey struct{}
+
+// requestOptions - per-request settings layered on top of the Client
+// defaults.  These travel on the request context so that every stage of
+// the pipeline sees them.
+type requestOptions struct {
+	retry   *RetryPolicy
+	hedge   *HedgePolicy
+	codec   Codec
+	auth    FixupCallback
+	expect  []int
+	timeout time.Duration
+}
+
+func withRequestOptions(ctx context.Context, opts *requestOptions) context.Context {
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// optionsFromContext - returns the per-request options on ctx, or nil.
+func optionsFromContext(ctx context.Context) *requestOptions {
+	opts, _ := ctx.Value(requestOptionsKey{}).(*requestOptions)
+	return opts
+}
+
+// isZero - reports whether opts changes nothing.
+func (opts *requestOptions) isZero() bool {
+	return opts.retry == nil && opts.hedge == nil && opts.codec == nil &&
+		opts.auth == nil && len(opts.expect) == 0 && opts.timeout == 0
+}
+
+// unexpectedStatus - reports whether a response status code should be
+// treated as an error.  Without RequestBuilder.Expect that is any status
+// >= 400, with it any status not listed.
+func (opts *requestOptions) unexpectedStatus(code int) bool {
+	if opts == nil || len(opts.expect) == 0 {
+		return code >= 400
+	}
+	for _, c := range opts.expect {
+		if c == code {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestBuilder - builds up a single request against a BaseClient.  Create
+// one with BaseClient.R, chain the setters, and finish with Do:
+//
+//	var out User
+//	resp, err := bc.R().
+//		Method("POST").
+//		Path("/users/{id}").
+//		PathParams(map[string]string{"id": id}).
+//		Header("X-Request-Id", reqID).
+//		Body(&UserUpdate{Name: "bob"}).
+//		Into(&out).
+//		Expect(201).
+//		Do(ctx)
+//
+// Requests go through the same validation, encoding and decoding as
+// BaseClient.ReqWithHeaders.  A RequestBuilder is not safe for concurrent
+// use, and should not be reused after Do.
+type RequestBuilder struct {
+	bc         *BaseClient
+	method     string
+	path       string
+	pathParams interface{}
+	query      interface{}
+	body       interface{}
+	into       interface{}
+	headers    http.Header
+	opts       requestOptions
+}
+
+// R - starts building a request.  The method defaults to GET.
+func (bc *BaseClient) R() *RequestBuilder {
+	return &RequestBuilder{
+		bc:     bc,
+		method: http.MethodGet,
+	}
+}
+
+// Method - sets the http method.
+func (rb *RequestBuilder) Method(method string) *RequestBuilder {
+	rb.method = method
+	return rb
+}
+
+// Path - sets the path, which is resolved against the BaseURL as described
+// for JoinURL.  It may be a template, see PathParams.
+func (rb *RequestBuilder) Path(path string) *RequestBuilder {
+	rb.path = path
+	return rb
+}
+
+// PathParams - sets the struct or map used to fill in the path template,
+// see WithPathParams.
+func (rb *RequestBuilder) PathParams(params interface{}) *RequestBuilder {
+	rb.pathParams = params
+	return rb
+}
+
+// Query - sets the query struct, which is validated and encoded with
+// go-querystring.
+func (rb *RequestBuilder) Query(queryStruct interface{}) *RequestBuilder {
+	rb.query = queryStruct
+	return rb
+}
+
+// Header - adds a request header.
+func (rb *RequestBuilder) Header(key, value string) *RequestBuilder {
+	if rb.headers == nil {
+		rb.headers = make(http.Header)
+	}
+	rb.headers.Add(key, value)
+	return rb
+}
+
+// Headers - adds all of the given request headers.
+func (rb *RequestBuilder) Headers(headers http.Header) *RequestBuilder {
+	for k, vals := range headers {
+		for _, v := range vals {
+			rb.Header(k, v)
+		}
+	}
+	return rb
+}
+
+// IdempotencyKey - sets the Idempotency-Key header, see WithIdempotencyKey.
+func (rb *RequestBuilder) IdempotencyKey(key string) *RequestBuilder {
+	if rb.headers == nil {
+		rb.headers = make(http.Header)
+	}
+	rb.headers.Set(IdempotencyKeyHeader, key)
+	return rb
+}
+
+// Body - sets the request body, which is validated and encoded.
+func (rb *RequestBuilder) Body(requestBody interface{}) *RequestBuilder {
+	rb.body = requestBody
+	return rb
+}
+
+// Into - sets where the response body is decoded to.
+func (rb *RequestBuilder) Into(responseBody interface{}) *RequestBuilder {
+	rb.into = responseBody
+	return rb
+}
+
+// Expect - lists the status codes that count as success.  Any other status,
+// including one below 400, is returned as a ResponseError.  Without this,
+// any status below 400 is a success.
+func (rb *RequestBuilder) Expect(codes ...int) *RequestBuilder {
+	rb.opts.expect = append(rb.opts.expect, codes...)
+	return rb
+}
+
+// Timeout - bounds the whole request, including any retries.  The clock
+// starts when the request is sent, so it also applies to requests made
+// with Build.
+func (rb *RequestBuilder) Timeout(d time.Duration) *RequestBuilder {
+	rb.opts.timeout = d
+	return rb
+}
+
+// Retry - overrides Client.Retry for this request.  Pass &RetryPolicy{} to
+// disable retries.
+func (rb *RequestBuilder) Retry(policy *RetryPolicy) *RequestBuilder {
+	rb.opts.retry = policy
+	return rb
+}
+
+// Hedge - overrides Client.Hedge for this request.  Pass &HedgePolicy{}
+// to disable hedging.
+func (rb *RequestBuilder) Hedge(policy *HedgePolicy) *RequestBuilder {
+	rb.opts.hedge = policy
+	return rb
+}
+
+// Codec - overrides Client.Codec for this request.
+func (rb *RequestBuilder) Codec(codec Codec) *RequestBuilder {
+	rb.opts.codec = codec
+	return rb
+}
+
+// Auth - sets a callback that runs after Client.FixupCallback, so that it
+// can replace whatever credentials the client would otherwise send.
+func (rb *RequestBuilder) Auth(auth FixupCallback) *RequestBuilder {
+	rb.opts.auth = auth
+	return rb
+}
+
+// context - attaches the per-request options to ctx.
+func (rb *RequestBuilder) context(ctx context.Context) context.Context {
+	opts := rb.opts
+	ctx = withRequestOptions(ctx, &opts)
+	if rb.pathParams != nil {
+		ctx = WithPathParams(ctx, rb.pathParams)
+	}
+	return ctx
+}
+
+// Build - builds the request without sending it, see Client.NewRequest.
+// The per-request options travel with the request, so they apply when it
+// is later sent with BaseClient.Do.
+func (rb *RequestBuilder) Build(ctx context.Context) (*http.Request, error) {
+	return rb.bc.NewRequest(rb.context(ctx), rb.method, rb.path, rb.query, rb.body, rb.headers)
+}
+
+// Do - sends the request.  Like ReqWithHeaders, the response is returned
+// with its body already read and closed.
+func (rb *RequestBuilder) Do(ctx context.Context) (*http.Response, error) {
+	return rb.bc.ReqWithHeaders(rb.context(ctx), rb.method, rb.path, rb.query, rb.body, rb.into, rb.headers)
+}
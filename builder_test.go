@@ -0,0 +1,146 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	var (
+		gotMethod, gotURI, gotAuth, gotReqID, gotUA string
+		gotBody                                     []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotURI = r.URL.RequestURI()
+		gotAuth = r.Header.Get("Authorization")
+		gotReqID = r.Header.Get("X-Request-Id")
+		gotUA = r.Header.Get("User-Agent")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(&testResponse{Foo: "foo", Baz: 59})
+	}))
+	defer srv.Close()
+
+	bc, err := NewBaseClient(srv.URL+"/api", &ClientConfig{
+		FixupCallback: func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer default")
+			req.Header.Set("User-Agent", "restclient-test")
+			return nil
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &testResponse{}
+	resp, err := bc.R().
+		Method("POST").
+		Path("/users/{id}").
+		PathParams(map[string]string{"id": "59"}).
+		Query(&testValidatorRequest{UID: "testuid"}).
+		Header("X-Request-Id", "abc").
+		Body(&testValidatorRequest{UID: "body", KeyType: "s3"}).
+		Into(out).
+		Expect(201).
+		Auth(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer override")
+			return nil
+		}).
+		Timeout(time.Second).
+		Do(context.Background())
+	if err != nil {
+		t.Fatal("Failed builder Do: ", err)
+	}
+	if resp.StatusCode != 201 || out.Foo != "foo" || out.Baz != 59 {
+		t.Logf("unexpected response %d %#v", resp.StatusCode, out)
+		t.Fail()
+	}
+	if gotMethod != "POST" || gotURI != "/api/users/59?uid=testuid" || gotReqID != "abc" {
+		t.Logf("unexpected request %s %s %q", gotMethod, gotURI, gotReqID)
+		t.Fail()
+	}
+	if gotAuth != "Bearer override" || gotUA != "restclient-test" {
+		t.Logf("expected auth override on top of FixupCallback, got %q %q", gotAuth, gotUA)
+		t.Fail()
+	}
+	if string(gotBody) != `{"UID":"body","SubUser":"","AccessKey":"","SecretKey":"","KeyType":"s3","GenerateKey":null}` {
+		t.Log("unexpected body ", string(gotBody))
+		t.Fail()
+	}
+
+	// 201 is not in the expected list this time.
+	_, err = bc.R().Path("/users").Expect(200).Do(context.Background())
+	if StatusCode(err) != 201 {
+		t.Log("expected unexpected status error, got ", err)
+		t.Fail()
+	}
+
+	// Validation still applies.
+	_, err = bc.R().Method("PUT").Body(&testValidatorRequest{}).Do(context.Background())
+	var ve ValidationErrors
+	if !errors.As(err, &ve) {
+		t.Log("expected validation error, got ", err)
+		t.Fail()
+	}
+}
+
+func TestRequestBuilderCodec(t *testing.T) {
+	var gotCT string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCT = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		// No content type, so only the codec knows how to read it.
+		w.Write(body)
+	}))
+	defer srv.Close()
+	bc, err := NewBaseClient(srv.URL, &ClientConfig{ProtoJSON: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := wrapperspb.String("hello")
+	out := &wrapperspb.StringValue{}
+	_, err = bc.R().Method("POST").Body(in).Into(out).Codec(ProtobufCodec{}).Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotCT != mediaTypeProtobuf || !proto.Equal(in, out) {
+		t.Logf("expected per-request codec to override ProtoJSON, got %s %v", gotCT, out)
+		t.Fail()
+	}
+}
+
+func TestRequestBuilderTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	bc := &BaseClient{Client: &Client{Client: &http.Client{}}, BaseURL: su}
+
+	_, err := bc.R().Timeout(20 * time.Millisecond).Do(context.Background())
+	if !IsTimeout(err) {
+		t.Log("expected timeout, got ", err)
+		t.Fail()
+	}
+
+	req, err := bc.R().Timeout(20 * time.Millisecond).Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bc.Do(req, nil); !IsTimeout(err) {
+		t.Log("expected timeout for a built request, got ", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,185 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// coalescer - tracks the requests in flight for Client.Coalesce, so that
+// identical ones can share a single call.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall - one shared call and its outcome.
+type coalescedCall struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// do - runs fn for the first caller with key, and makes any caller arriving
+// while it runs wait for and share its result, or give up with ctx's error
+// once its own ctx is done.
+func (co *coalescer) do(ctx context.Context, key string, fn func() (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	co.mu.Lock()
+	if c, ok := co.calls[key]; ok {
+		co.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.resp, c.body, c.err
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	if co.calls == nil {
+		co.calls = make(map[string]*coalescedCall)
+	}
+	c := &coalescedCall{done: make(chan struct{})}
+	co.calls[key] = c
+	co.mu.Unlock()
+
+	defer func() {
+		co.mu.Lock()
+		delete(co.calls, key)
+		co.mu.Unlock()
+		close(c.done)
+	}()
+	c.resp, c.body, c.err = fn()
+	return c.resp, c.body, c.err
+}
+
+// rawBody - a CustomDecoder that just keeps the body, so that a shared
+// response can be decoded separately for each caller.
+type rawBody struct {
+	b []byte
+}
+
+func (rb *rawBody) Decode(data io.Reader) error {
+	var err error
+	rb.b, err = io.ReadAll(data)
+	return err
+}
+
+// coalescable - reports whether req may share a call with others.  A
+// request with per-request options, e.g. from a RequestBuilder, is always
+// sent alone, since its codec, expected statuses or credentials may differ
+// from those of otherwise identical requests.
+func coalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if opts := optionsFromContext(req.Context()); opts != nil && !opts.isZero() {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// coalesceKey - identifies requests that are interchangeable: the same
+// method, final URL and headers, or only CoalesceHeaders if set.  Accept
+// and any credentials always have to match, so that no caller is given a response meant for someone else.
+func (cl *Client) coalesceKey(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+	names := cl.CoalesceHeaders
+	if names == nil {
+		names = make([]string, 0, len(req.Header))
+		for k := range req.Header {
+			names = append(names, k)
+		}
+	} else {
+		names = append([]string{"Accept", "Authorization", "Proxy-Authorization", "Cookie"}, names...)
+		rd := cl.redactor()
+		for k := range req.Header {
+			if rd.IsSecretHeader(k) {
+				names = append(names, k)
+			}
+		}
+	}
+	seen := make(map[string]bool, len(names))
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		k := http.CanonicalHeaderKey(name)
+		if !seen[k] {
+			seen[k] = true
+			canonical = append(canonical, k)
+		}
+	}
+	sort.Strings(canonical)
+	for _, k := range canonical {
+		b.WriteByte('\n')
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header[k], ","))
+	}
+	return b.String()
+}
+
+// coalescedDo - Do for requests that may share a call.  The shared call
+// keeps the raw body, which each caller then decodes into its own
+// responseBody.  Each caller gets its own copy of the response and error.
+func (cl *Client) coalescedDo(req *http.Request, responseBody interface{}) (*http.Response, error) {
+	if _, ok := responseBody.(proto.Message); ok && cl.Codec == nil && req.Header.Get("Accept") == "" {
+		// The shared call can't see responseBody, so ask for proto here,
+		// which also keeps it apart from callers wanting json.
+		req = req.Clone(req.Context())
+		req.Header["Accept"] = []string{cl.protoMediaType()}
+	}
+	ctx := req.Context()
+	resp, body, err := cl.flights.do(ctx, cl.coalesceKey(req), func() (*http.Response, []byte, error) {
+		raw := &rawBody{}
+		resp, err := cl.do(req, raw)
+		return resp, raw.b, err
+	})
+	if err != nil && err == ctx.Err() {
+		return nil, cl.requestError(req.Method, req.URL.String(), RouteFromContext(ctx), 1, PhaseTransport, err)
+	}
+	if resp != nil {
+		rc := *resp
+		rc.Header = resp.Header.Clone()
+		resp = &rc
+	}
+	err = cl.copyError(resp, err)
+	if err != nil || isNil(responseBody) {
+		return resp, err
+	}
+	if err = cl.decodeBody(resp, bytes.NewReader(body), responseBody); err != nil {
+		return resp, cl.debugError(cl.requestError(req.Method, req.URL.String(), RouteFromContext(req.Context()), 1, PhaseDecode, err), req)
+	}
+	return resp, nil
+}
+
+// copyError - copies the RequestError, and any ResponseError within it,
+// shared by coalesced callers, so that none of them sees another modify
+// it.  The ResponseError's ErrorBody is decoded afresh from the body for
+// each caller.  An error returned by ErrorResponseCallback is shared as
+// it is.
+func (cl *Client) copyError(resp *http.Response, err error) error {
+	rqe, ok := err.(*RequestError)
+	if !ok {
+		return err
+	}
+	cp := *rqe
+	if rse, ok := rqe.Err.(*ResponseError); ok {
+		rc := *rse
+		rc.Header = rse.Header.Clone()
+		rc.ResponseBody = append([]byte(nil), rse.ResponseBody...)
+		if rse.ErrorBody != nil && resp != nil {
+			rc.ErrorBody = cl.decodeErrorBody(resp, rc.ResponseBody)
+		}
+		cp.Err = &rc
+	}
+	cp.Dump = append([]byte(nil), rqe.Dump...)
+	return &cp
+}
@@ -0,0 +1,219 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"Foo":"foo","Baz":59}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{Coalesce: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	outs := make([]*testResponse, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outs[i] = &testResponse{}
+			errs[i] = cl.Get(context.Background(), su, "/thing?x=1", nil, outs[i])
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Log("expected one shared call, got ", calls)
+		t.Fail()
+	}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil || outs[i].Foo != "foo" || outs[i].Baz != 59 {
+			t.Logf("caller %d got %#v %v", i, outs[i], errs[i])
+			t.Fail()
+		}
+	}
+	outs[0].Foo = "changed"
+	if outs[1].Foo != "foo" {
+		t.Log("expected every caller to decode its own copy")
+		t.Fail()
+	}
+
+	// Requests differing in headers don't share.
+	atomic.StoreInt32(&calls, 0)
+	for _, user := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			cl.ReqWithHeaders(context.Background(), su, "GET", "/thing?x=1", nil, nil, nil,
+				http.Header{"Authorization": {"Bearer " + user}})
+		}(user)
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Log("expected separate calls for different credentials, got ", calls)
+		t.Fail()
+	}
+}
+
+func TestCoalesceKeepsCallersApart(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		if r.Header.Get("Accept") == mediaTypeProtobuf {
+			b, _ := proto.Marshal(wrapperspb.String("proto"))
+			w.Header().Set("Content-Type", mediaTypeProtobuf)
+			w.Write(b)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{Coalesce: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.CoalesceHeaders = []string{"X-Tenant"}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	// Credentials always have to match, even when not listed.
+	var wg sync.WaitGroup
+	for _, user := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			bc.ReqWithHeaders(context.Background(), "GET", "/thing", nil, nil, nil,
+				http.Header{"Authorization": {"Bearer " + user}})
+		}(user)
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Log("expected separate calls for different credentials, got ", calls)
+		t.Fail()
+	}
+
+	// A proto caller asks for proto, apart from json callers.
+	atomic.StoreInt32(&calls, 0)
+	pout := &wrapperspb.StringValue{}
+	jout := &testResponse{}
+	var perr, jerr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, perr = bc.ReqWithHeaders(context.Background(), "GET", "/thing", nil, nil, pout, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		_, jerr = bc.ReqWithHeaders(context.Background(), "GET", "/thing", nil, nil, jout, nil)
+	}()
+	wg.Wait()
+	if perr != nil || jerr != nil || pout.Value != "proto" || jout.Foo != "foo" || calls != 2 {
+		t.Logf("unexpected results %v %v %#v %#v after %d calls", perr, jerr, pout, jout, calls)
+		t.Fail()
+	}
+
+	// Requests with per-request options are sent alone.
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bc.R().Path("/missing").Expect(http.StatusNotFound).Do(context.Background())
+		}()
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Log("expected requests with options not to share, got ", calls)
+		t.Fail()
+	}
+
+	// Every caller gets its own error.
+	bc.RegisterStatusError(404, 404, &testAPIError{})
+	atomic.StoreInt32(&calls, 0)
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = bc.ReqWithHeaders(context.Background(), "GET", "/missing", nil, nil, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 || errs[0] == nil || errs[0] == errs[1] {
+		t.Logf("expected one call and separate errors, got %d calls, %v %v", calls, errs[0], errs[1])
+		t.Fail()
+	}
+	var rse0, rse1 *ResponseError
+	if errors.As(errs[0], &rse0) && errors.As(errs[1], &rse1) {
+		rse0.ResponseBody[0] = 'X'
+		if rse1.ResponseBody[0] == 'X' {
+			t.Log("expected separate response bodies")
+			t.Fail()
+		}
+	} else {
+		t.Log("expected ResponseErrors, got ", errs)
+		t.Fail()
+	}
+	var ae0, ae1 *testAPIError
+	if !errors.As(errs[0], &ae0) || !errors.As(errs[1], &ae1) || ae0 == ae1 {
+		t.Log("expected separate error bodies, got ", errs)
+		t.Fail()
+	}
+}
+
+func TestCoalesceJoinerContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{Coalesce: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := make(chan error, 1)
+	go func() { leader <- cl.Get(context.Background(), su, "/thing", nil, &testResponse{}) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// A caller joining the call can still give up on its own.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = cl.Get(ctx, su, "/thing", nil, &testResponse{})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 200*time.Millisecond {
+		t.Logf("expected the joiner to time out alone, got %v after %s", err, time.Since(start))
+		t.Fail()
+	}
+	if err = <-leader; err != nil {
+		t.Log("expected the leader to finish, got ", err)
+		t.Fail()
+	}
+}
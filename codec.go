@@ -0,0 +1,168 @@
+package restclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/go-querystring/query"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec - encodes request bodies and decodes response bodies.  Setting
+// Client.Codec, or a per-request codec with RequestBuilder.Codec, replaces
+// the default behavior of picking json, form or protobuf encoding from the
+// client settings and body type.  A response body implementing
+// CustomDecoder still takes priority over the codec.
+type Codec interface {
+	// ContentType - the media type sent in the Content-Type header.
+	ContentType() string
+
+	// Marshal - encodes a request body.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal - decodes a response body into v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec - encodes and decodes with encoding/json.
+type JSONCodec struct{}
+
+// ContentType - implements Codec.
+func (JSONCodec) ContentType() string { return mediaTypeJSON }
+
+// Marshal - implements Codec.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal - implements Codec.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// FormCodec - encodes request bodies as x-www-form-urlencoded using the
+// `url` struct tags, like Client.FormEncodedBody.  It cannot decode.
+type FormCodec struct{}
+
+// ContentType - implements Codec.
+func (FormCodec) ContentType() string { return mediaTypeForm }
+
+// Marshal - implements Codec.
+func (FormCodec) Marshal(v interface{}) ([]byte, error) {
+	vals, err := query.Values(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(vals.Encode()), nil
+}
+
+// Unmarshal - implements Codec, always failing.
+func (FormCodec) Unmarshal(data []byte, v interface{}) error {
+	return errors.New("form codec cannot decode response bodies")
+}
+
+// ProtobufCodec - encodes and decodes proto.Message values with the
+// protobuf wire format.
+type ProtobufCodec struct{}
+
+// ContentType - implements Codec.
+func (ProtobufCodec) ContentType() string { return mediaTypeProtobuf }
+
+// Marshal - implements Codec.
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec requires a proto.Message, got %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal - implements Codec.
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec requires a proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// ProtoJSONCodec - encodes and decodes proto.Message values with the
+// protojson mapping.
+type ProtoJSONCodec struct{}
+
+// ContentType - implements Codec.
+func (ProtoJSONCodec) ContentType() string { return mediaTypeJSON }
+
+// Marshal - implements Codec.
+func (ProtoJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protojson codec requires a proto.Message, got %T", v)
+	}
+	return protojson.Marshal(m)
+}
+
+// Unmarshal - implements Codec.
+func (ProtoJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protojson codec requires a proto.Message, got %T", v)
+	}
+	return protojson.Unmarshal(data, m)
+}
+
+// codec - the codec in effect for a request, either the per-request one or
+// Client.Codec.  nil means the default behavior.
+func (cl *Client) codec(opts *requestOptions) Codec {
+	if opts != nil && opts.codec != nil {
+		return opts.codec
+	}
+	return cl.Codec
+}
+
+// defaultContentType - the Content-Type sent when the caller doesn't set one.
+func (cl *Client) defaultContentType(opts *requestOptions) string {
+	if c := cl.codec(opts); c != nil {
+		return c.ContentType()
+	}
+	if cl.FormEncodedBody {
+		return mediaTypeForm
+	}
+	return mediaTypeJSON
+}
+
+// encodeBody - encodes a request body, returning it along with its content
+// type.
+func (cl *Client) encodeBody(opts *requestOptions, body interface{}) ([]byte, string, error) {
+	if c := cl.codec(opts); c != nil {
+		b, err := c.Marshal(body)
+		return b, c.ContentType(), err
+	}
+	if pm, ok := body.(proto.Message); ok {
+		return cl.marshalProto(pm)
+	}
+	if cl.FormEncodedBody {
+		b, err := FormCodec{}.Marshal(body)
+		return b, mediaTypeForm, err
+	}
+	b, err := json.Marshal(body)
+	return b, mediaTypeJSON, err
+}
+
+// decodeWithCodec - decodes r into v with the codec in effect for resp's
+// request, reporting false if there is none.
+func (cl *Client) decodeWithCodec(resp *http.Response, r io.Reader, v interface{}) (bool, error) {
+	var opts *requestOptions
+	if resp.Request != nil {
+		opts = optionsFromContext(resp.Request.Context())
+	}
+	c := cl.codec(opts)
+	if c == nil {
+		return false, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return true, err
+	}
+	return true, c.Unmarshal(data, v)
+}
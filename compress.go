@@ -0,0 +1,207 @@
+package restclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings understood by the client.
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// DefaultCompressionThreshold - request bodies smaller than this many bytes
+// are sent uncompressed when Client.CompressionThreshold is not set.
+const DefaultCompressionThreshold = 1024
+
+// acceptEncoding - the Accept-Encoding header sent when
+// Client.DecompressResponses is enabled.
+const acceptEncoding = EncodingGzip + ", " + EncodingZstd + ", " + EncodingBrotli
+
+// compressBody - compresses b with the given content coding if it is large
+// enough to be worth it.  The second return value reports whether the body
+// was compressed.
+func (cl *Client) compressBody(b []byte) ([]byte, bool, error) {
+	if cl.RequestCompression == "" {
+		return b, false, nil
+	}
+	threshold := cl.CompressionThreshold
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if len(b) < threshold {
+		return b, false, nil
+	}
+
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch cl.RequestCompression {
+	case EncodingGzip:
+		w = gzip.NewWriter(buf)
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, false, err
+		}
+		w = zw
+	default:
+		return nil, false, fmt.Errorf("unsupported request compression %q", cl.RequestCompression)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// decodedBody - a decompressing reader over a response body, built on the
+// first Read so that an empty body decodes to nothing rather than failing
+// on a missing gzip header.  Closing it also closes the underlying body.
+type decodedBody struct {
+	r       io.Reader
+	codings []string
+	started bool
+	closers []func() error
+}
+
+func (db *decodedBody) Read(p []byte) (int, error) {
+	if !db.started {
+		db.started = true
+		if err := db.start(); err != nil {
+			db.r = errReader{err}
+		}
+	}
+	return db.r.Read(p)
+}
+
+// start - stacks up the decoders, removing codings in the reverse order
+// they were applied.
+func (db *decodedBody) start() error {
+	for i := len(db.codings) - 1; i >= 0; i-- {
+		switch db.codings[i] {
+		case EncodingGzip, "x-gzip":
+			gr, err := gzip.NewReader(db.r)
+			if err != nil {
+				return err
+			}
+			db.r = gr
+			db.closers = append(db.closers, gr.Close)
+		case EncodingZstd:
+			zr, err := zstd.NewReader(db.r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			db.r = zr
+			db.closers = append(db.closers, func() error { zr.Close(); return nil })
+		case EncodingBrotli:
+			db.r = brotli.NewReader(db.r)
+		}
+	}
+	return nil
+}
+
+func (db *decodedBody) Close() error {
+	var err error
+	for i := len(db.closers) - 1; i >= 0; i-- {
+		if cerr := db.closers[i](); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type errReader struct{ err error }
+
+func (er errReader) Read([]byte) (int, error) { return 0, er.err }
+
+// hasNoBody - reports whether resp can't have a body, whatever its headers
+// say.
+func hasNoBody(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return true
+	}
+	return resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified ||
+		(resp.StatusCode >= 100 && resp.StatusCode < 200)
+}
+
+// decompressResponse - replaces resp.Body with a reader that undoes any
+// Content-Encoding applied by the server.  Codings are removed in the
+// reverse order they were applied, and the decoders are only created once
+// the body is read, so an empty body is fine.  Like net/http does for
+// gzip, the Content-Encoding and Content-Length headers are dropped and
+// resp.Uncompressed is set once the body is decoded.  Responses that can't
+// have a body are left alone.
+func decompressResponse(resp *http.Response) error {
+	ce := resp.Header.Get("Content-Encoding")
+	if ce == "" || hasNoBody(resp) {
+		return nil
+	}
+	var codings []string
+	for _, c := range strings.Split(ce, ",") {
+		switch coding := strings.ToLower(strings.TrimSpace(c)); coding {
+		case "", "identity":
+		case EncodingGzip, "x-gzip", EncodingZstd, EncodingBrotli:
+			codings = append(codings, coding)
+		default:
+			return fmt.Errorf("unsupported response Content-Encoding %q", coding)
+		}
+	}
+	resp.Body = &decodedBody{r: resp.Body, codings: codings, closers: []func() error{resp.Body.Close}}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decompressErrorResponse - reads the body of an error response and
+// replaces it with the decoded body, or, if it can't be decoded, with the
+// bytes as they came, headers and all.
+func decompressErrorResponse(resp *http.Response) {
+	if resp.Header.Get("Content-Encoding") == "" || hasNoBody(resp) {
+		return
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	body := raw
+	if header, decoded, err := Decompress(resp.Header, raw); err == nil {
+		resp.Header, body = header, decoded
+		resp.ContentLength = int64(len(body))
+		resp.Uncompressed = true
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+}
+
+// Decompress - undoes the Content-Encoding listed in header on body, for
+// tools such as recorders that need to look inside request or response
+// bodies.  It returns a copy of header without the Content-Encoding and
+// Content-Length, along with the decoded body.
+func Decompress(header http.Header, body []byte) (http.Header, []byte, error) {
+	resp := &http.Response{Header: header.Clone(), Body: io.NopCloser(bytes.NewReader(body))}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if resp.Header.Get("Content-Encoding") == "" {
+		return resp.Header, body, nil
+	}
+	if err := decompressResponse(resp); err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	decoded, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Header, decoded, nil
+}
@@ -0,0 +1,187 @@
+package restclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRequestCompression(t *testing.T) {
+	var (
+		gotEncoding string
+		gotBody     []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		var rd io.Reader = r.Body
+		switch gotEncoding {
+		case EncodingGzip:
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			rd = gr
+		case EncodingZstd:
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			defer zr.Close()
+			rd = zr
+		}
+		gotBody, _ = io.ReadAll(rd)
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	big := &testResponse{Foo: strings.Repeat("foo", 1000), Bar: "bar", Baz: 59}
+	small := &testResponse{Foo: "foo"}
+
+	for _, enc := range []string{EncodingGzip, EncodingZstd} {
+		cl, err := NewClient(&ClientConfig{RequestCompression: enc}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cl.Post(context.Background(), su, "/ingest", nil, big, nil)
+		if err != nil {
+			t.Fatalf("%s: Failed client.Post: %s", enc, err)
+		}
+		if gotEncoding != enc {
+			t.Logf("%s: expected Content-Encoding %s, got %q", enc, enc, gotEncoding)
+			t.Fail()
+		}
+		want, _ := json.Marshal(big)
+		if !bytes.Equal(want, gotBody) {
+			t.Logf("%s: body not preserved through compression", enc)
+			t.Fail()
+		}
+
+		err = cl.Post(context.Background(), su, "/ingest", nil, small, nil)
+		if err != nil {
+			t.Fatalf("%s: Failed client.Post: %s", enc, err)
+		}
+		if gotEncoding != "" {
+			t.Logf("%s: body below threshold should not be compressed, got Content-Encoding %q", enc, gotEncoding)
+			t.Fail()
+		}
+	}
+}
+
+func TestResponseDecompression(t *testing.T) {
+	tr := &testResponse{Foo: "foo", Bar: "bar", Baz: 59}
+	payload, _ := json.Marshal(tr)
+
+	encoders := map[string]func(w io.Writer) io.WriteCloser{
+		EncodingGzip: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		EncodingZstd: func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+		EncodingBrotli: func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	}
+
+	var gotAccept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept-Encoding")
+		enc := strings.TrimPrefix(r.URL.Path, "/")
+		w.Header().Set("Content-Encoding", enc)
+		ew := encoders[enc](w)
+		ew.Write(payload)
+		ew.Close()
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{DecompressResponses: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for enc := range encoders {
+		tr2 := &testResponse{}
+		resp, err := cl.Req(context.Background(), su, "GET", enc, nil, nil, tr2)
+		if err != nil {
+			t.Fatalf("%s: Failed client.Req: %s", enc, err)
+		}
+		if *tr != *tr2 {
+			t.Logf("%s: data not preserved on round trip", enc)
+			t.Fail()
+		}
+		if resp.Header.Get("Content-Encoding") != "" || !resp.Uncompressed {
+			t.Logf("%s: expected response to be marked as uncompressed", enc)
+			t.Fail()
+		}
+		if gotAccept != acceptEncoding {
+			t.Logf("expected Accept-Encoding %q, got %q", acceptEncoding, gotAccept)
+			t.Fail()
+		}
+	}
+}
+
+func TestResponseDecompressionNoBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", EncodingGzip)
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/empty":
+		case "/broken":
+			w.WriteHeader(500)
+			w.Write([]byte("not gzip"))
+		case "/unsupported":
+			w.Header().Set("Content-Encoding", "compress")
+			w.WriteHeader(502)
+			w.Write([]byte("compressed"))
+		case "/gzipped":
+			w.WriteHeader(503)
+			gw := gzip.NewWriter(w)
+			gw.Write([]byte("unavailable"))
+			gw.Close()
+		}
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{DecompressResponses: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := cl.Req(ctx, su, "HEAD", "/empty", nil, nil, nil); err != nil {
+		t.Log("HEAD: ", err)
+		t.Fail()
+	}
+	if _, err := cl.Req(ctx, su, "GET", "/nocontent", nil, nil, nil); err != nil {
+		t.Log("204: ", err)
+		t.Fail()
+	}
+	if _, err := cl.Req(ctx, su, "GET", "/empty", nil, nil, nil); err != nil {
+		t.Log("empty body: ", err)
+		t.Fail()
+	}
+	for path, want := range map[string]string{
+		"/broken":      "not gzip",
+		"/unsupported": "compressed",
+		"/gzipped":     "unavailable",
+	} {
+		_, err := cl.Req(ctx, su, "GET", path, nil, nil, &testResponse{})
+		var re *ResponseError
+		if !errors.As(err, &re) || re.StatusCode < 500 || string(re.ResponseBody) != want {
+			t.Log(path, ": expected the status error with body ", want, ", got ", err)
+			t.Fail()
+		}
+	}
+}
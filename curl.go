@@ -0,0 +1,160 @@
+package restclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Curl - renders req, normally built by NewRequest, as an equivalent curl
+// command line, with secrets in the URL, headers and body replaced by the
+// client's Redactor.  A compressed body is rendered decompressed, without
+// its Content-Encoding header, so the command stays readable and can be
+// redacted.
+func (cl *Client) Curl(req *http.Request) (string, error) {
+	header, body, err := cl.redactedRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if body != nil && !utf8.Valid(body) {
+		// Binary bodies can't be quoted safely, so feed them through stdin.
+		b.WriteString("printf '")
+		for _, c := range body {
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+		b.WriteString("' | ")
+	}
+	b.WriteString("curl")
+	switch {
+	case req.Method == http.MethodHead:
+		b.WriteString(" --head")
+	case req.Method != http.MethodGet || body != nil:
+		b.WriteString(" -X " + req.Method)
+	}
+	b.WriteString(" " + shellQuote(cl.redactor().URL(req.URL)))
+	if req.Host != "" && req.Host != req.URL.Host {
+		b.WriteString(" \\\n  -H " + shellQuote("Host: "+req.Host))
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range header[k] {
+			b.WriteString(" \\\n  -H " + shellQuote(k+": "+v))
+		}
+	}
+	switch {
+	case body == nil:
+	case utf8.Valid(body):
+		b.WriteString(" \\\n  --data-binary " + shellQuote(string(body)))
+	default:
+		b.WriteString(" \\\n  --data-binary @-")
+	}
+	return b.String(), nil
+}
+
+// DumpRequest - returns the wire form of req, as httputil.DumpRequestOut
+// would produce it, with secrets replaced by the client's Redactor.  Like
+// Curl, a compressed body is dumped decompressed.
+func (cl *Client) DumpRequest(req *http.Request) ([]byte, error) {
+	header, body, err := cl.redactedRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(cl.redactor().URL(req.URL))
+	if err != nil {
+		return nil, err
+	}
+	dreq := req.Clone(req.Context())
+	dreq.URL = u
+	dreq.Header = header
+	dreq.Header.Del("Content-Length")
+	dreq.Body = nil
+	dreq.GetBody = nil
+	dreq.ContentLength = 0
+	if body != nil {
+		dreq.Body = io.NopCloser(bytes.NewReader(body))
+		dreq.ContentLength = int64(len(body))
+	}
+	return httputil.DumpRequestOut(dreq, true)
+}
+
+// redactedRequest - returns the redacted headers and decompressed, redacted
+// body of req.  req itself is left untouched; the body is read through
+// GetBody.
+func (cl *Client) redactedRequest(req *http.Request) (http.Header, []byte, error) {
+	header, body, err := decodedRequestBody(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := cl.redactor()
+	if body != nil {
+		body = rd.Body(header.Get("Content-Type"), body)
+	}
+	return rd.Header(header), body, nil
+}
+
+// decodedRequestBody - reads the body of req through GetBody, undoing any
+// Content-Encoding.  The returned header is a copy of req.Header without
+// the Content-Encoding.  A request without a body returns a nil body.
+func decodedRequestBody(req *http.Request) (http.Header, []byte, error) {
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if req.GetBody == nil {
+		return header, nil, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rc == http.NoBody {
+		return header, nil, nil
+	}
+	resp := &http.Response{Header: header, Body: rc}
+	if err = decompressResponse(resp); err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, body, nil
+}
+
+// shellQuote - quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// debugError - when DebugErrors is enabled, attaches the curl command and
+// request dump for req to err, which must come from requestError.
+func (cl *Client) debugError(err error, req *http.Request) error {
+	rqe, ok := err.(*RequestError)
+	if !cl.DebugErrors || !ok {
+		return err
+	}
+	if curl, cerr := cl.Curl(req); cerr == nil {
+		rqe.Curl = curl
+	}
+	if dump, derr := cl.DumpRequest(req); derr == nil {
+		rqe.Dump = dump
+	}
+	return rqe
+}
@@ -0,0 +1,94 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCurl(t *testing.T) {
+	cl, err := NewClient(&ClientConfig{RequestCompression: EncodingGzip, CompressionThreshold: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://api.example.com/v1")
+	hdr := http.Header{"Authorization": {"Bearer hunter2"}, "X-Note": {"it's"}}
+	req, err := cl.NewRequest(context.Background(), base, "POST", "login?api_key=k1", nil,
+		&testLogin{User: "bob", Password: "hunter2"}, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cl.Curl(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `curl -X POST 'https://api.example.com/v1/login?api_key=REDACTED' \
+  -H 'Authorization: REDACTED' \
+  -H 'Content-Type: application/json' \
+  -H 'X-Note: it'\''s' \
+  --data-binary '{"password":"REDACTED","user":"bob"}'`
+	if got != want {
+		t.Logf("expected\n%s\ngot\n%s", want, got)
+		t.Fail()
+	}
+
+	dump, err := cl.DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"POST /v1/login?api_key=REDACTED HTTP/1.1", "Host: api.example.com", "Authorization: REDACTED", `"password":"REDACTED"`} {
+		if !strings.Contains(string(dump), s) {
+			t.Logf("expected dump to contain %q, got\n%s", s, dump)
+			t.Fail()
+		}
+	}
+	if strings.Contains(string(dump), "hunter2") || strings.Contains(string(dump), "Content-Encoding") {
+		t.Log("unexpected dump ", string(dump))
+		t.Fail()
+	}
+
+	req, err = cl.NewRequest(context.Background(), base, "HEAD", "ping", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = cl.Curl(req)
+	if !strings.HasPrefix(got, "curl --head 'https://api.example.com/v1/ping'") {
+		t.Log("unexpected HEAD command ", got)
+		t.Fail()
+	}
+}
+
+func TestDebugErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Post(context.Background(), su, "/login", nil, &testLogin{User: "bob", Password: "hunter2"}, nil)
+	var rqe *RequestError
+	if !errors.As(err, &rqe) || rqe.Curl != "" || rqe.Dump != nil {
+		t.Log("expected no debug output by default, got ", err)
+		t.Fail()
+	}
+
+	cl.DebugErrors = true
+	err = cl.Post(context.Background(), su, "/login", nil, &testLogin{User: "bob", Password: "hunter2"}, nil)
+	if !errors.As(err, &rqe) || !strings.HasPrefix(rqe.Curl, "curl -X POST '"+srv.URL+"/login'") {
+		t.Log("expected curl command on error, got ", rqe.Curl)
+		t.Fail()
+	}
+	if !strings.Contains(string(rqe.Dump), `"password":"REDACTED"`) || strings.Contains(rqe.Curl, "hunter2") {
+		t.Logf("unexpected debug output %s\n%s", rqe.Curl, rqe.Dump)
+		t.Fail()
+	}
+}
@@ -21,6 +21,7 @@
 //
 //    import (
 //    	"context"
+//    	"errors"
 //    	"fmt"
 //    	"net/http"
 //    	"net/url"
@@ -94,18 +95,22 @@
 //    	resp := []UserCap{}
 //    	err := bc.Put(context.Background(), "/user?caps", ucr, nil, &resp)
 //    	if err != nil {
-//    		if _, ok := err.(rc.ValidationErrors); ok {
+//    		// Errors are always *rc.RequestError, which records the method,
+//    		// url and phase of the failure and wraps the underlying error.
+//    		var ve rc.ValidationErrors
+//    		var re *rc.ResponseError
+//    		if errors.As(err, &ve) {
 //    			// We had an error on validation from the query string.
-//    			// Note that there is no pointer on the type assertion.  This
+//    			// Note that there is no pointer on the target type.  This
 //    			// follows the playground validator package ValidationErrors.
 //
-//    			fmt.Println("Got a validation error: ", err)
-//    		} else if e, ok := err.(*rc.ResponseError); ok {
+//    			fmt.Println("Got a validation error: ", ve)
+//    		} else if errors.As(err, &re) {
 //    			// We had a response code >= 400 from the server.  Note
-//    			// the pointer on the type assertion above.  This
+//    			// the pointer on the target type above.  This
 //    			// is to avoid breaking backwards compatibility.
 //
-//    			fmt.Printf("Got a response error code: %d\n", e.StatusCode)
+//    			fmt.Printf("Got a response error code: %d\n", re.StatusCode)
 //    		} else {
 //    			// Something else went wrong.
 //    			fmt.Println("Got unknown error: ", err)
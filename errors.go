@@ -0,0 +1,162 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Phase - identifies the stage of a request at which an error occurred.
+type Phase string
+
+// The phases of a request, in the order they happen.
+const (
+	// PhaseValidate - the query struct or request body failed validation.
+	PhaseValidate Phase = "validate"
+
+	// PhaseEncode - the query or request body could not be encoded, or the
+	// request could not be built.
+	PhaseEncode Phase = "encode"
+
+	// PhaseTransport - the request could not be sent, or no response was
+	// received.
+	PhaseTransport Phase = "transport"
+
+	// PhaseStatus - the server responded with a status code >= 400.
+	PhaseStatus Phase = "status"
+
+	// PhaseDecode - the response body could not be decoded.
+	PhaseDecode Phase = "decode"
+)
+
+// RequestError - every error returned by Client.ReqWithHeaders, and so by
+// all the request methods, is one of these.  It records where the request
+// failed and wraps the underlying error, so the previous error types are
+// still reachable with errors.As:
+//
+//	var ve restclient.ValidationErrors
+//	var re *restclient.ResponseError
+//	switch {
+//	case errors.As(err, &ve):
+//	case errors.As(err, &re):
+//	}
+type RequestError struct {
+	// Method - the http method of the request.
+	Method string
+
+	// URL - the request URL, with secrets removed by the client's Redactor.
+	URL string
+
+	// Route - the path template of the request, if one was used.  See
+	// WithPathParams.
+	Route string
+
+	// Attempt - the attempt number that failed, starting at 1.
+	Attempt int
+
+	// Phase - the stage of the request that failed.
+	Phase Phase
+
+	// Err - the underlying error.
+	Err error
+
+	// Curl - the request as a curl command line, see Client.Curl.  Only
+	// set when Client.DebugErrors is enabled and the request was built.
+	Curl string
+
+	// Dump - the request in wire format, see Client.DumpRequest.  Only
+	// set when Client.DebugErrors is enabled and the request was built.
+	Dump []byte
+}
+
+// Error - implement the Error interface.
+func (e *RequestError) Error() string {
+	if e.Attempt > 1 {
+		return fmt.Sprintf("%s %s (attempt %d): %s", e.Method, e.URL, e.Attempt, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Err)
+}
+
+// Unwrap - returns the underlying error.
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// requestError - wraps err in a RequestError.  Transport errors from
+// net/http carry the full URL, so they are replaced by a redacted copy,
+// leaving the original untouched.
+func (cl *Client) requestError(method, rawURL, route string, attempt int, phase Phase, err error) error {
+	redacted := cl.redactURLString(rawURL)
+	if ue, ok := err.(*url.Error); ok {
+		cp := *ue
+		cp.URL = redacted
+		err = &cp
+	}
+	return &RequestError{
+		Method:  method,
+		URL:     redacted,
+		Route:   route,
+		Attempt: attempt,
+		Phase:   phase,
+		Err:     err,
+	}
+}
+
+// StatusCode - returns the response status code carried by err, or 0 if the
+// request didn't get as far as a response.
+func StatusCode(err error) int {
+	var re *ResponseError
+	if errors.As(err, &re) {
+		return re.StatusCode
+	}
+	return 0
+}
+
+// IsTimeout - reports whether err is the result of a timeout, either the
+// http.Client timeout, a dial or tls timeout, or a context deadline.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// IsRetryable - reports whether retrying the same request might succeed.
+// That is the case for transport errors other than cancellation, and for
+// 408, 425, 429, 500, 502, 503 and 504 responses.  Validation, encoding and
+// decoding errors, and requests rejected by a Limiter, are never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || IsRejected(err) {
+		return false
+	}
+	var rqe *RequestError
+	if errors.As(err, &rqe) {
+		switch rqe.Phase {
+		case PhaseValidate, PhaseEncode, PhaseDecode:
+			return false
+		case PhaseTransport:
+			return true
+		}
+	}
+	switch StatusCode(err) {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsNotFound - reports whether err is a 404 response.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsConflict - reports whether err is a 409 response.
+func IsConflict(err error) bool {
+	return StatusCode(err) == http.StatusConflict
+}
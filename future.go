@@ -0,0 +1,154 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Future - the pending result of a request started in the background.  The
+// response body passed when starting it must not be used until the future
+// is done.  Requests started this way go through the Client like any
+// other, so a Client.Limiter bounds how many run at once.
+type Future struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	resp   *http.Response
+	err    error
+}
+
+// Async - runs fn in the background with a context derived from ctx, which
+// is cancelled once fn returns or the future is cancelled.
+func Async(ctx context.Context, fn func(ctx context.Context) (*http.Response, error)) *Future {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &Future{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		f.resp, f.err = fn(ctx)
+	}()
+	return f
+}
+
+// Done - returns a channel closed when the request has finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel - cancels the request if it hasn't finished yet.
+func (f *Future) Cancel() {
+	f.cancel()
+}
+
+// Result - waits for the request to finish and returns its outcome.
+func (f *Future) Result() (*http.Response, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// Wait - like Result, except it gives up and returns ctx's error when ctx
+// is done first, leaving the request running.
+func (f *Future) Wait(ctx context.Context) (*http.Response, error) {
+	select {
+	case <-f.done:
+		return f.resp, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetAsync - like BaseClient.Get, but returns straight away with a Future.
+func (bc *BaseClient) GetAsync(ctx context.Context, path string, queryStruct interface{}, responseBody interface{}) *Future {
+	return bc.ReqAsync(ctx, "GET", path, queryStruct, nil, responseBody)
+}
+
+// DeleteAsync - like BaseClient.Delete, but returns straight away with a
+// Future.
+func (bc *BaseClient) DeleteAsync(ctx context.Context, path string, queryStruct interface{}, responseBody interface{}) *Future {
+	return bc.ReqAsync(ctx, "DELETE", path, queryStruct, nil, responseBody)
+}
+
+// PostAsync - like BaseClient.Post, but returns straight away with a Future.
+func (bc *BaseClient) PostAsync(ctx context.Context, path string, queryStruct, requestBody interface{}, responseBody interface{}) *Future {
+	return bc.ReqAsync(ctx, "POST", path, queryStruct, requestBody, responseBody)
+}
+
+// PutAsync - like BaseClient.Put, but returns straight away with a Future.
+func (bc *BaseClient) PutAsync(ctx context.Context, path string, queryStruct, requestBody interface{}, responseBody interface{}) *Future {
+	return bc.ReqAsync(ctx, "PUT", path, queryStruct, requestBody, responseBody)
+}
+
+// ReqAsync - like BaseClient.Req, but returns straight away with a Future.
+func (bc *BaseClient) ReqAsync(ctx context.Context, method, path string, queryStruct,
+	requestBody interface{}, responseBody interface{}) *Future {
+	return Async(ctx, func(ctx context.Context) (*http.Response, error) {
+		return bc.Req(ctx, method, path, queryStruct, requestBody, responseBody)
+	})
+}
+
+// AwaitAll - waits for every future to finish, returning their errors
+// joined together, or nil if they all succeeded.  If ctx is done first,
+// the futures still running are cancelled and ctx's error is returned.
+func AwaitAll(ctx context.Context, futures ...*Future) error {
+	var errs []error
+	for _, f := range futures {
+		select {
+		case <-f.done:
+			if f.err != nil {
+				errs = append(errs, f.err)
+			}
+		case <-ctx.Done():
+			for _, f := range futures {
+				f.Cancel()
+			}
+			return ctx.Err()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AwaitTimeout - like AwaitAll, with a deadline of timeout from now shared
+// by all the futures.
+func AwaitTimeout(timeout time.Duration, futures ...*Future) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return AwaitAll(ctx, futures...)
+}
+
+// AwaitFirst - waits for the first future to succeed, cancels the rest and
+// returns its index.  If they all fail it returns -1 and their errors
+// joined together, and if ctx is done first it cancels them all and
+// returns -1 and ctx's error.
+func AwaitFirst(ctx context.Context, futures ...*Future) (int, error) {
+	finished := make(chan int, len(futures))
+	for i, f := range futures {
+		go func(i int, f *Future) {
+			<-f.done
+			finished <- i
+		}(i, f)
+	}
+	cancelAll := func(except int) {
+		for i, f := range futures {
+			if i != except {
+				f.Cancel()
+			}
+		}
+	}
+
+	var errs []error
+	for range futures {
+		select {
+		case i := <-finished:
+			if futures[i].err == nil {
+				cancelAll(i)
+				return i, nil
+			}
+			errs = append(errs, futures[i].err)
+		case <-ctx.Done():
+			cancelAll(-1)
+			return -1, ctx.Err()
+		}
+	}
+	return -1, errors.Join(errs...)
+}
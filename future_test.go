@@ -0,0 +1,131 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFutureServer(t *testing.T) (*BaseClient, *int32, func()) {
+	var cancelled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-r.Context().Done()
+			atomic.AddInt32(&cancelled, 1)
+			return
+		case "/fail":
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"foo":%q}`, r.URL.Path)
+	}))
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &BaseClient{Client: cl, BaseURL: su}, &cancelled, srv.Close
+}
+
+func TestAwaitAll(t *testing.T) {
+	bc, _, done := newFutureServer(t)
+	defer done()
+	bh := &Bulkhead{MaxConcurrent: 2, MaxQueue: 5}
+	bc.Client.Limiter = bh
+
+	ctx := context.Background()
+	out := make([]testResponse, 5)
+	var futures []*Future
+	for i := range out {
+		futures = append(futures, bc.GetAsync(ctx, fmt.Sprintf("/%d", i), nil, &out[i]))
+	}
+	if err := AwaitAll(ctx, futures...); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range out {
+		if r.Foo != fmt.Sprintf("/%d", i) {
+			t.Log("unexpected response ", i, r)
+			t.Fail()
+		}
+	}
+	if st := bh.Stats()[""]; st.Admitted != 5 {
+		t.Logf("expected the futures to go through the limiter, got %#v", st)
+		t.Fail()
+	}
+
+	err := AwaitAll(ctx, bc.GetAsync(ctx, "/ok", nil, nil), bc.GetAsync(ctx, "/fail", nil, nil))
+	var re *ResponseError
+	if !errors.As(err, &re) || re.StatusCode != 500 {
+		t.Log("expected the failure, got ", err)
+		t.Fail()
+	}
+}
+
+func TestAwaitFirst(t *testing.T) {
+	bc, cancelled, done := newFutureServer(t)
+	defer done()
+	ctx := context.Background()
+
+	var fast testResponse
+	futures := []*Future{
+		bc.GetAsync(ctx, "/slow", nil, nil),
+		bc.GetAsync(ctx, "/fail", nil, nil),
+		bc.GetAsync(ctx, "/fast", nil, &fast),
+	}
+	i, err := AwaitFirst(ctx, futures...)
+	if err != nil || i != 2 || fast.Foo != "/fast" {
+		t.Fatal("expected the fast future to win, got ", i, err)
+	}
+	if _, err := futures[0].Result(); !errors.Is(err, context.Canceled) {
+		t.Log("expected the slow future to be cancelled, got ", err)
+		t.Fail()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(cancelled) == 1 })
+
+	i, err = AwaitFirst(ctx, bc.GetAsync(ctx, "/fail", nil, nil), bc.GetAsync(ctx, "/fail", nil, nil))
+	if i != -1 || err == nil {
+		t.Log("expected every future to fail, got ", i, err)
+		t.Fail()
+	}
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	bc, cancelled, done := newFutureServer(t)
+	defer done()
+	ctx := context.Background()
+
+	fast := bc.GetAsync(ctx, "/fast", nil, nil)
+	slow := bc.GetAsync(ctx, "/slow", nil, nil)
+	start := time.Now()
+	if err := AwaitTimeout(50*time.Millisecond, fast, slow); !errors.Is(err, context.DeadlineExceeded) {
+		t.Log("expected the deadline to pass, got ", err)
+		t.Fail()
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Log("took too long ", d)
+		t.Fail()
+	}
+	if _, err := fast.Result(); err != nil {
+		t.Log("expected the fast future to succeed, got ", err)
+		t.Fail()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(cancelled) == 1 })
+
+	f := bc.GetAsync(ctx, "/slow", nil, nil)
+	wctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Wait(wctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Log("expected Wait to give up, got ", err)
+		t.Fail()
+	}
+	f.Cancel()
+	<-f.Done()
+}
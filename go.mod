@@ -3,9 +3,13 @@ module github.com/myENA/restclient
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/go-playground/validator/v10 v10.22.0
 	github.com/google/go-querystring v1.1.0
+	github.com/klauspost/compress v1.17.9
 	github.com/spkg/bom v1.0.0
+	golang.org/x/net v0.27.0
+	google.golang.org/protobuf v1.34.2
 )
 
 require (
@@ -14,7 +18,6 @@ require (
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	golang.org/x/crypto v0.25.0 // indirect
-	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 )
@@ -0,0 +1,477 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultHARMaxBodySize - the body size cap used when HARRecorder.MaxBodySize
+// is not set.
+const DefaultHARMaxBodySize = 64 * 1024
+
+// HARRecorder - records every attempt made by a Client as an HTTP Archive
+// (HAR 1.2) entry, for handing a faithful record of traffic to someone
+// else.  Enable it by setting Client.HAR:
+//
+//	rec := &restclient.HARRecorder{}
+//	cl.HAR = rec
+//	...
+//	err = rec.WriteFile("session.har")
+//
+// Headers, query parameters and bodies are scrubbed with the client's
+// Redactor before they are recorded.  A HARRecorder may be shared between
+// clients and is safe for concurrent use.
+type HARRecorder struct {
+	// MaxBodySize - request and response bodies are recorded up to this
+	// many bytes.  Zero means DefaultHARMaxBodySize, negative means bodies
+	// are not recorded at all.
+	MaxBodySize int
+
+	mu      sync.Mutex
+	entries []HAREntry
+}
+
+// HAR - the top level of an HTTP Archive.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog - the log of an HTTP Archive.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator - the application that created the archive.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry - a single request and response.  Attempt, Route and Error are
+// HAR custom fields describing where the entry came from.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Attempt         int         `json:"_attempt,omitempty"`
+	Route           string      `json:"_route,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+// HARRequest - the request half of an entry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARResponse - the response half of an entry.  A transport failure is
+// recorded with a zero Status.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARNameValue - a header, cookie or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData - a recorded request body.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent - a recorded response body.  Size is the decoded size of the
+// whole body, even when Text was truncated.
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings - the phases of an entry in milliseconds, -1 where a phase
+// did not happen, such as dns and connect on a reused connection.  SSL is
+// included in Connect, as the spec requires.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// Entries - returns a copy of the entries recorded so far.
+func (hr *HARRecorder) Entries() []HAREntry {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return append([]HAREntry(nil), hr.entries...)
+}
+
+// Reset - discards all recorded entries.
+func (hr *HARRecorder) Reset() {
+	hr.mu.Lock()
+	hr.entries = nil
+	hr.mu.Unlock()
+}
+
+// HAR - returns the recorded entries as an archive.
+func (hr *HARRecorder) HAR() *HAR {
+	entries := hr.Entries()
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "github.com/myENA/restclient", Version: "1"},
+		Entries: entries,
+	}}
+}
+
+// WriteTo - writes the archive as json to w.
+func (hr *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(hr.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// WriteFile - writes the archive as json to the named file.
+func (hr *HARRecorder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = hr.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (hr *HARRecorder) maxBodySize() int {
+	if hr.MaxBodySize == 0 {
+		return DefaultHARMaxBodySize
+	}
+	return hr.MaxBodySize
+}
+
+func (hr *HARRecorder) add(e HAREntry) {
+	hr.mu.Lock()
+	hr.entries = append(hr.entries, e)
+	hr.mu.Unlock()
+}
+
+// harExchange - the state of one attempt being recorded.
+type harExchange struct {
+	hr      *HARRecorder
+	req     *http.Request
+	reqBody []byte
+	attempt int
+
+	// base - the request context without the trace, for hedged copies.
+	base context.Context
+
+	mu                        sync.Mutex
+	start, gotConn            time.Time
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wroteRequest, firstByte   time.Time
+	serverIP                  string
+	respBody                  *harCapture
+}
+
+// startHAR - begins recording req, returning the exchange whose req
+// carries the tracing context and should be sent in place of req.
+func (cl *Client) startHAR(req *http.Request, attempt int) *harExchange {
+	x := &harExchange{hr: cl.HAR, attempt: attempt, base: req.Context()}
+	_, x.reqBody, _ = decodedRequestBody(req)
+	x.trace(req)
+	return x
+}
+
+// fork - starts a separate exchange for a hedged copy of the request,
+// sharing the request body already captured.  req must not carry the
+// original's trace, so is built on x.base.
+func (x *harExchange) fork(req *http.Request) *harExchange {
+	fx := &harExchange{hr: x.hr, attempt: x.attempt, base: x.base, reqBody: x.reqBody}
+	fx.trace(req)
+	return fx
+}
+
+// trace - sets x.req to req with a trace recording the timings into x.
+func (x *harExchange) trace(req *http.Request) {
+	x.start = time.Now()
+	stamp := func(t *time.Time) func() {
+		return func() {
+			x.mu.Lock()
+			*t = time.Now()
+			x.mu.Unlock()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			x.mu.Lock()
+			x.gotConn = time.Now()
+			if info.Conn != nil {
+				if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+					x.serverIP = host
+				}
+			}
+			x.mu.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { stamp(&x.dnsStart)() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { stamp(&x.dnsDone)() },
+		ConnectStart:         func(string, string) { stamp(&x.connectStart)() },
+		ConnectDone:          func(string, string, error) { stamp(&x.connectDone)() },
+		TLSHandshakeStart:    stamp(&x.tlsStart),
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { stamp(&x.tlsDone)() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { stamp(&x.wroteRequest)() },
+		GotFirstResponseByte: stamp(&x.firstByte),
+	}
+	x.req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// captureBody - wraps the decoded response body so that what the client
+// reads of it is recorded.
+func (x *harExchange) captureBody(body io.ReadCloser) io.ReadCloser {
+	x.respBody = &harCapture{ReadCloser: body, limit: x.hr.maxBodySize()}
+	return x.respBody
+}
+
+// finishHAR - records the exchange.  resp is nil when the attempt failed
+// before a response arrived.
+func (cl *Client) finishHAR(x *harExchange, resp *http.Response, err error) {
+	end := time.Now()
+	rd := cl.redactor()
+	limit := x.hr.maxBodySize()
+
+	e := HAREntry{
+		StartedDateTime: x.start,
+		Attempt:         x.attempt,
+		Route:           RouteFromContext(x.req.Context()),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		if ue, ok := err.(*url.Error); ok {
+			e.Error = (&url.Error{Op: ue.Op, URL: rd.URL(x.req.URL), Err: ue.Err}).Error()
+		}
+	}
+
+	proto := "HTTP/1.1"
+	if resp != nil && resp.Proto != "" {
+		proto = resp.Proto
+	}
+	e.Request = HARRequest{
+		Method:      x.req.Method,
+		URL:         rd.URL(x.req.URL),
+		HTTPVersion: proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(rd.Header(x.req.Header)),
+		QueryString: harQuery(rd.RawQuery(x.req.URL.RawQuery)),
+		HeadersSize: -1,
+		BodySize:    x.req.ContentLength,
+	}
+	if x.reqBody != nil {
+		ct := x.req.Header.Get("Content-Type")
+		pd := &HARPostData{MimeType: ct}
+		body, truncated := x.reqBody, false
+		if limit >= 0 && len(body) > limit {
+			body, truncated = body[:limit], true
+		}
+		pd.Text, pd.Encoding, pd.Comment = harBody(rd, ct, body, truncated, limit)
+		if ce := x.req.Header.Get("Content-Encoding"); ce != "" {
+			note := "decoded from Content-Encoding " + ce
+			if pd.Comment != "" {
+				note += ", " + pd.Comment
+			}
+			pd.Comment = note
+		}
+		e.Request.PostData = pd
+	}
+
+	e.Response = HARResponse{
+		HTTPVersion: proto,
+		Cookies:     []HARNameValue{},
+		Headers:     []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if resp != nil {
+		ct := resp.Header.Get("Content-Type")
+		e.Response.Status = resp.StatusCode
+		e.Response.StatusText = strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+		e.Response.Headers = harHeaders(rd.Header(resp.Header))
+		e.Response.RedirectURL = resp.Header.Get("Location")
+		e.Response.Content.MimeType = ct
+		if resp.ContentLength >= 0 && resp.Header.Get("Content-Encoding") == "" {
+			e.Response.BodySize = resp.ContentLength
+		}
+		if c := x.respBody; c != nil {
+			e.Response.Content.Size = c.n
+			if limit >= 0 {
+				e.Response.Content.Text, e.Response.Content.Encoding, e.Response.Content.Comment =
+					harBody(rd, ct, c.buf.Bytes(), c.n > int64(limit), limit)
+			}
+		}
+	}
+
+	x.mu.Lock()
+	e.Timings, e.Time = x.timings(end)
+	e.ServerIPAddress = x.serverIP
+	x.mu.Unlock()
+	x.hr.add(e)
+}
+
+// timings - works out the HAR phases from the trace timestamps.
+func (x *harExchange) timings(end time.Time) (HARTimings, float64) {
+	span := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return -1
+		}
+		return float64(to.Sub(from)) / float64(time.Millisecond)
+	}
+	t := HARTimings{
+		DNS:     span(x.dnsStart, x.dnsDone),
+		Connect: span(x.connectStart, x.connectDone),
+		SSL:     span(x.tlsStart, x.tlsDone),
+		Send:    span(x.gotConn, x.wroteRequest),
+		Wait:    span(x.wroteRequest, x.firstByte),
+		Receive: span(x.firstByte, end),
+	}
+	if t.Connect >= 0 && t.SSL >= 0 {
+		t.Connect += t.SSL
+	}
+	t.Blocked = span(x.start, x.gotConn)
+	for _, phase := range []float64{t.DNS, t.Connect} {
+		if t.Blocked >= 0 && phase >= 0 {
+			t.Blocked -= phase
+		}
+	}
+	if t.Blocked < 0 && !x.gotConn.IsZero() {
+		t.Blocked = 0
+	}
+	total := span(x.start, end)
+	if total < 0 {
+		total = 0
+	}
+	return t, total
+}
+
+// harBody - renders a captured body, redacted, as HAR text.  A truncated
+// json body can't be parsed to find its secrets, so it is left out rather
+// than recorded unredacted.
+func harBody(rd *Redactor, contentType string, body []byte, truncated bool, limit int) (text, encoding, comment string) {
+	if truncated {
+		comment = "body truncated to " + strconv.Itoa(limit) + " bytes"
+		mt, _, _ := mime.ParseMediaType(contentType)
+		if mt == mediaTypeJSON || strings.HasSuffix(mt, "+json") {
+			return "", "", "json body larger than " + strconv.Itoa(limit) + " bytes omitted"
+		}
+	}
+	body = rd.Body(contentType, body)
+	if utf8.Valid(body) {
+		return string(body), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64", comment
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	nvs := []HARNameValue{}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			nvs = append(nvs, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+func harQuery(rawQuery string) []HARNameValue {
+	nvs := []HARNameValue{}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		nvs = append(nvs, HARNameValue{Name: name, Value: value})
+	}
+	return nvs
+}
+
+// harCapture - a response body that keeps the first limit bytes read from it
+// and counts the rest.
+type harCapture struct {
+	io.ReadCloser
+	limit int
+	buf   bytes.Buffer
+	n     int64
+}
+
+func (c *harCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if room := c.limit - c.buf.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		c.buf.Write(p[:room])
+	}
+	c.n += int64(n)
+	return n, err
+}
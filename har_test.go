@@ -0,0 +1,119 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testLogin struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func TestHARRecorder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=s3cret")
+		if r.URL.Path == "/big" {
+			w.Write([]byte(`{"data":"` + strings.Repeat("x", 100) + `"}`))
+			return
+		}
+		w.Write([]byte(`{"token":"s3cret","user":"bob"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &HARRecorder{MaxBodySize: 64}
+	cl.HAR = rec
+
+	hdr := http.Header{"Authorization": {"Bearer hunter2"}}
+	_, err = cl.ReqWithHeaders(context.Background(), su, "POST", "/login?api_key=k1&v=2", nil,
+		&testLogin{User: "bob", Password: "hunter2"}, nil, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.Get(context.Background(), su, "/big", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := rec.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cret", "k1"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Logf("secret %s leaked into har: %s", secret, buf)
+			t.Fail()
+		}
+	}
+
+	har := &HAR{}
+	if err := json.Unmarshal(buf.Bytes(), har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("unexpected har log %#v", har.Log)
+	}
+	login := har.Log.Entries[0]
+	if login.Request.Method != "POST" || login.Response.Status != 200 || login.Response.StatusText != "OK" || login.Attempt != 1 {
+		t.Logf("unexpected login entry %#v", login)
+		t.Fail()
+	}
+	if login.Request.PostData == nil || !strings.Contains(login.Request.PostData.Text, `"user":"bob"`) {
+		t.Log("expected redacted request body, got ", login.Request.PostData)
+		t.Fail()
+	}
+	if len(login.Request.QueryString) != 2 || login.Request.QueryString[1] != (HARNameValue{Name: "v", Value: "2"}) {
+		t.Log("unexpected query string ", login.Request.QueryString)
+		t.Fail()
+	}
+	if login.Response.Content.Size != 31 || login.Timings.Wait < 0 || login.Time <= 0 || login.ServerIPAddress == "" {
+		t.Logf("unexpected content or timings %#v %#v", login.Response.Content, login.Timings)
+		t.Fail()
+	}
+
+	big := har.Log.Entries[1].Response.Content
+	if big.Size != 111 || big.Text != "" || big.Comment == "" {
+		t.Logf("expected oversized json body to be omitted, got %#v", big)
+		t.Fail()
+	}
+
+	rec.Reset()
+	if len(rec.Entries()) != 0 {
+		t.Log("expected Reset to discard entries")
+		t.Fail()
+	}
+}
+
+func TestHARRecorderTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	su, _ := url.Parse(srv.URL)
+	srv.Close()
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.HAR = &HARRecorder{}
+	if err = cl.Get(context.Background(), su, "/x?token=hunter2", nil, nil); err == nil {
+		t.Fatal("expected transport error against closed server")
+	}
+	entries := cl.HAR.Entries()
+	if len(entries) != 1 || entries[0].Error == "" || entries[0].Response.Status != 0 {
+		t.Fatalf("unexpected entries %#v", entries)
+	}
+	if strings.Contains(entries[0].Error, "hunter2") || strings.Contains(entries[0].Request.URL, "hunter2") {
+		t.Log("secret leaked into failed entry ", entries[0])
+		t.Fail()
+	}
+}
@@ -0,0 +1,350 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHedgeWindow = 100
+	minHedgeSamples    = 10
+	defaultMaxHedges   = 1
+)
+
+// HedgePolicy - sends a second copy of a slow request and takes whichever
+// response arrives first, cancelling the other.  This trims the tail
+// latency caused by one slow backend, at the cost of some extra load.  Set
+// it on Client.Hedge for every request, or override it for a single
+// request with RequestBuilder.Hedge.  A policy collects latency samples and
+// statistics, so share one between the requests that should be measured
+// together, and don't copy it after use.
+//
+// Hedging happens within each attempt, so it combines with RetryPolicy:
+// an attempt fails only when every copy sent for it has failed.
+type HedgePolicy struct {
+	// Delay - how long to wait for a response before sending a hedge.
+	// When Percentile is set, this is only used until enough latencies
+	// have been seen, and zero means requests aren't hedged until then.
+	Delay time.Duration
+
+	// Percentile - when between 0 and 1, hedges are sent once a request
+	// has taken longer than this percentile of recent latencies, e.g.
+	// 0.95 for p95.  The latencies are those of the original copy of every
+	// eligible request, whether hedged or not.
+	Percentile float64
+
+	// Window - how many recent latencies Percentile is computed over.
+	// Zero means 100.
+	Window int
+
+	// MaxHedges - how many extra copies may be sent, each Delay after the
+	// last.  Zero means 1.
+	MaxHedges int
+
+	// Methods - the http methods that may be hedged.  nil means the
+	// idempotent methods GET, HEAD, OPTIONS, TRACE, PUT and DELETE.
+	Methods []string
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+
+	requests    atomic.Uint64
+	hedges      atomic.Uint64
+	primaryWins atomic.Uint64
+	hedgeWins   atomic.Uint64
+}
+
+// HedgeStats - counters kept by a HedgePolicy.
+type HedgeStats struct {
+	// Requests - requests that were eligible for hedging.
+	Requests uint64
+
+	// Hedges - extra copies sent.
+	Hedges uint64
+
+	// PrimaryWins - requests answered by the original copy.
+	PrimaryWins uint64
+
+	// HedgeWins - requests answered by a hedge.
+	HedgeWins uint64
+}
+
+// Stats - returns a snapshot of the policy's counters.
+func (hp *HedgePolicy) Stats() HedgeStats {
+	return HedgeStats{
+		Requests:    hp.requests.Load(),
+		Hedges:      hp.hedges.Load(),
+		PrimaryWins: hp.primaryWins.Load(),
+		HedgeWins:   hp.hedgeWins.Load(),
+	}
+}
+
+// CurrentDelay - returns how long a request will currently wait before
+// being hedged, or zero if it won't be hedged.
+func (hp *HedgePolicy) CurrentDelay() time.Duration {
+	if hp.Percentile <= 0 || hp.Percentile >= 1 {
+		return hp.Delay
+	}
+	hp.mu.Lock()
+	samples := append([]time.Duration(nil), hp.latencies...)
+	hp.mu.Unlock()
+	if len(samples) < minHedgeSamples {
+		return hp.Delay
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[int(hp.Percentile*float64(len(samples)-1))]
+}
+
+// observe - records the latency of a winning response.
+func (hp *HedgePolicy) observe(d time.Duration) {
+	if hp.Percentile <= 0 {
+		return
+	}
+	window := hp.Window
+	if window <= 0 {
+		window = defaultHedgeWindow
+	}
+	hp.mu.Lock()
+	if len(hp.latencies) < window {
+		hp.latencies = append(hp.latencies, d)
+	} else {
+		hp.latencies[hp.next%len(hp.latencies)] = d
+		hp.next++
+	}
+	hp.mu.Unlock()
+}
+
+func (hp *HedgePolicy) allowsMethod(method string) bool {
+	methods := hp.Methods
+	if methods == nil {
+		methods = idempotentMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgePolicy - the hedge policy in effect for a request, either the
+// per-request one or Client.Hedge.
+func (cl *Client) hedgePolicy(opts *requestOptions) *HedgePolicy {
+	if opts != nil && opts.hedge != nil {
+		return opts.hedge
+	}
+	return cl.Hedge
+}
+
+// errHedgeLost - recorded in the HAR for copies cancelled because another
+// copy answered first.
+var errHedgeLost = errors.New("hedged copy cancelled, another copy answered first")
+
+// hedgeResult - the outcome of one copy of a hedged request.
+type hedgeResult struct {
+	n    int
+	resp *http.Response
+	err  error
+
+	// skipped - the copy was never sent, because the Limiter refused it.
+	skipped bool
+}
+
+// hedgeCopy - one copy of a hedged request in flight.
+type hedgeCopy struct {
+	cancel context.CancelFunc
+
+	// done - releases the copy's Limiter slot.  nil for the original,
+	// whose slot is held by send.  Hedges set it before their result is
+	// delivered.
+	done func(*http.Response, error)
+
+	// hx - the copy's HAR exchange, if recording.  Hedges set it before
+	// their result is delivered.
+	hx *harExchange
+}
+
+func (hc *hedgeCopy) release(resp *http.Response, err error) {
+	if hc.done != nil {
+		hc.done(resp, err)
+	}
+}
+
+// cancelOnClose - a response body that cancels the request's context, and
+// releases its Limiter slot, once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	hc   *hedgeCopy
+	resp *http.Response
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.hc.cancel()
+	c.hc.release(c.resp, nil)
+	return err
+}
+
+// roundTrip - sends req with cl.Client, hedging it according to the policy
+// in effect.  Each hedged copy waits for its own Limiter slot, and is
+// skipped if it can't get one, and has its own HAR trace.  The HAR
+// exchange of the copy that answered is returned, which is hx unless a
+// hedge won, and every other copy's exchange is finished here, or nil when
+// every copy failed and all of them were.
+func (cl *Client) roundTrip(req *http.Request, hx *harExchange) (*http.Response, *harExchange, error) {
+	hp := cl.hedgePolicy(optionsFromContext(req.Context()))
+	if hp == nil || !hp.allowsMethod(req.Method) {
+		resp, err := cl.Client.Do(req)
+		return resp, hx, err
+	}
+	hp.requests.Add(1)
+	start := time.Now()
+	delay := hp.CurrentDelay()
+	if delay <= 0 {
+		// Not hedged, at least not yet, but still measured.
+		resp, err := cl.Client.Do(req)
+		if err == nil {
+			hp.primaryWins.Add(1)
+			hp.observe(time.Since(start))
+		}
+		return resp, hx, err
+	}
+	maxHedges := hp.MaxHedges
+	if maxHedges <= 0 {
+		maxHedges = defaultMaxHedges
+	}
+
+	results := make(chan hedgeResult, maxHedges+1)
+	var copies []*hedgeCopy
+	launch := func() {
+		n := len(copies)
+		if n == 0 {
+			ctx, cancel := context.WithCancel(req.Context())
+			copies = append(copies, &hedgeCopy{cancel: cancel, hx: hx})
+			r := req.WithContext(ctx)
+			go func() {
+				resp, err := cl.Client.Do(r)
+				results <- hedgeResult{n: n, resp: resp, err: err}
+			}()
+			return
+		}
+
+		// Hedges start from the context without the original's trace.
+		base := req.Context()
+		if hx != nil {
+			base = hx.base
+		}
+		ctx, cancel := context.WithCancel(base)
+		hc := &hedgeCopy{cancel: cancel}
+		copies = append(copies, hc)
+		r := req.Clone(ctx)
+		go func() {
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					results <- hedgeResult{n: n, err: err, skipped: true}
+					return
+				}
+				r.Body = body
+			}
+			// A hedge is optional, so it doesn't exceed the limits, and
+			// any wait for a slot ends once another copy answers.
+			done, err := cl.acquire(r)
+			if err != nil {
+				results <- hedgeResult{n: n, err: err, skipped: true}
+				return
+			}
+			hc.done = done
+			if hx != nil {
+				hc.hx = hx.fork(r)
+				r = hc.hx.req
+			}
+			hp.hedges.Add(1)
+			resp, err := cl.Client.Do(r)
+			results <- hedgeResult{n: n, resp: resp, err: err}
+		}()
+	}
+
+	launch()
+	inflight := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case <-timer.C:
+			if len(copies) <= maxHedges {
+				launch()
+				inflight++
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			inflight--
+			hc := copies[res.n]
+			if res.skipped {
+				hc.cancel()
+				continue
+			}
+			if res.err != nil {
+				hc.cancel()
+				hc.release(nil, res.err)
+				if hc.hx != nil {
+					cl.finishHAR(hc.hx, nil, res.err)
+				}
+				lastErr = res.err
+				continue
+			}
+			for i, other := range copies {
+				if i != res.n {
+					other.cancel()
+				}
+			}
+			go cl.drainHedges(results, copies, inflight)
+			if res.n == 0 {
+				hp.primaryWins.Add(1)
+			} else {
+				hp.hedgeWins.Add(1)
+			}
+			// The original's latency, or when a hedge won, the least it
+			// would have been, which is never below the current delay.
+			hp.observe(time.Since(start))
+			res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, hc: hc, resp: res.resp}
+			return res.resp, hc.hx, nil
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// drainHedges - waits for the n cancelled copies still in flight after
+// another copy won, closing any response they got anyway, releasing their
+// Limiter slots and finishing their HAR exchanges.
+func (cl *Client) drainHedges(results <-chan hedgeResult, copies []*hedgeCopy, n int) {
+	for i := 0; i < n; i++ {
+		res := <-results
+		hc := copies[res.n]
+		if res.skipped {
+			continue
+		}
+		if res.resp != nil {
+			io.Copy(ioutil.Discard, res.resp.Body)
+			res.resp.Body.Close()
+		}
+		err := res.err
+		if err == nil {
+			err = context.Canceled
+		}
+		hc.release(nil, err)
+		if hc.hx != nil {
+			cl.finishHAR(hc.hx, res.resp, errHedgeLost)
+		}
+	}
+}
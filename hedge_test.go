@@ -0,0 +1,227 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	var calls, cancelled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/slow-first" && n == 1 {
+			select {
+			case <-r.Context().Done():
+				atomic.AddInt32(&cancelled, 1)
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HedgePolicy{Delay: 20 * time.Millisecond}
+	cl.Hedge = hp
+
+	out := &testResponse{}
+	start := time.Now()
+	if err = cl.Get(context.Background(), su, "/slow-first", nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Foo != "foo" || time.Since(start) > time.Second {
+		t.Logf("expected fast hedged response, got %#v after %s", out, time.Since(start))
+		t.Fail()
+	}
+	if st := hp.Stats(); st != (HedgeStats{Requests: 1, Hedges: 1, HedgeWins: 1}) {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&cancelled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&cancelled) != 1 {
+		t.Log("expected the losing request to be cancelled")
+		t.Fail()
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	if err = cl.Get(context.Background(), su, "/fast", nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if st := hp.Stats(); st.PrimaryWins != 1 || st.Hedges != 1 || calls != 1 {
+		t.Logf("expected primary win without hedge, got %#v after %d calls", st, calls)
+		t.Fail()
+	}
+
+	// POST isn't hedged.
+	atomic.StoreInt32(&calls, 0)
+	if err = cl.Post(context.Background(), su, "/fast", nil, &testResponse{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if hp.Stats().Requests != 2 {
+		t.Log("expected POST to bypass hedging")
+		t.Fail()
+	}
+}
+
+func TestHedgeLimiterAndHAR(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HedgePolicy{Delay: 50 * time.Millisecond}
+	cl.Hedge = hp
+	bh := &Bulkhead{MaxConcurrent: 1}
+	cl.Limiter = bh
+
+	// With no spare slot the hedge isn't sent.
+	if err = cl.Get(context.Background(), su, "/", nil, &testResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if st := hp.Stats(); st.Hedges != 0 || st.PrimaryWins != 1 {
+		t.Logf("expected the limiter to stop the hedge, got %#v", st)
+		t.Fail()
+	}
+	if st := bh.Stats()[""]; st.Admitted != 1 || st.Rejected != 1 {
+		t.Logf("unexpected bulkhead stats %#v", st)
+		t.Fail()
+	}
+
+	bh = &Bulkhead{MaxConcurrent: 2}
+	cl.Limiter = bh
+	cl.HAR = &HARRecorder{}
+	atomic.StoreInt32(&calls, 0)
+	if err = cl.Get(context.Background(), su, "/", nil, &testResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if st := hp.Stats(); st.Hedges != 1 || st.HedgeWins != 1 {
+		t.Logf("expected a winning hedge, got %#v", st)
+		t.Fail()
+	}
+	waitFor(t, func() bool { return bh.Stats()[""].InFlight == 0 })
+	if st := bh.Stats()[""]; st.Admitted != 2 {
+		t.Logf("expected the hedge to take a slot, got %#v", st)
+		t.Fail()
+	}
+	// The cancelled original is recorded too, once it is drained.
+	waitFor(t, func() bool { return len(cl.HAR.Entries()) == 2 })
+	var won, lost int
+	for _, e := range cl.HAR.Entries() {
+		switch {
+		case e.Error == errHedgeLost.Error():
+			lost++
+		case e.Error == "" && e.Time < 40:
+			won++
+		}
+	}
+	if won != 1 || lost != 1 {
+		t.Logf("expected an entry for each copy, got %#v", cl.HAR.Entries())
+		t.Fail()
+	}
+}
+
+func TestHedgeQueuedSlot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HedgePolicy{Delay: 20 * time.Millisecond}
+	cl.Hedge = hp
+	bh := &Bulkhead{MaxConcurrent: 1, MaxQueue: 1}
+	cl.Limiter = bh
+
+	// The hedge queues for the slot the original holds, which mustn't
+	// hold up the original's response.
+	start := time.Now()
+	if err = cl.Get(context.Background(), su, "/", nil, &testResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Log("expected the original's response without waiting for the hedge, took ", d)
+		t.Fail()
+	}
+	waitFor(t, func() bool { st := bh.Stats()[""]; return st.InFlight == 0 && st.Queued == 0 })
+	if st := hp.Stats(); st.PrimaryWins != 1 || st.HedgeWins != 0 {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+}
+
+func TestHedgeLearnsWithoutDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &HedgePolicy{Percentile: 0.9}
+	cl.Hedge = hp
+	for i := 0; i < minHedgeSamples; i++ {
+		if err = cl.Get(context.Background(), su, "/", nil, &testResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := hp.CurrentDelay(); d <= 0 {
+		t.Log("expected unhedged requests to be measured, got ", d)
+		t.Fail()
+	}
+	if st := hp.Stats(); st.Requests != minHedgeSamples || st.Hedges != 0 {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+}
+
+func TestHedgePercentile(t *testing.T) {
+	hp := &HedgePolicy{Delay: time.Second, Percentile: 0.9, Window: 20}
+	for i := 1; i <= 5; i++ {
+		hp.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := hp.CurrentDelay(); d != time.Second {
+		t.Log("expected fallback delay before enough samples, got ", d)
+		t.Fail()
+	}
+	for i := 1; i <= 30; i++ {
+		hp.observe(time.Duration(i) * time.Millisecond)
+	}
+	// The window holds the last 20 samples, 11ms to 30ms.
+	if d := hp.CurrentDelay(); d != 28*time.Millisecond {
+		t.Log("expected p90 of the window, got ", d)
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+package restclient
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// IdempotencyKeyHeader - the header carrying the idempotency key, which
+// lets the server recognise a retried request it has already processed.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey - returns a context that makes requests made with it
+// carry key in the Idempotency-Key header, whether or not
+// Client.IdempotencyKeys is enabled.  Use this when the key has to be tied
+// to something the caller knows, such as an order id, so that it survives
+// a restart of the caller.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// IdempotencyKeyFromContext - returns the key set with WithIdempotencyKey,
+// or "".
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+// NewIdempotencyKey - returns a random (version 4) UUID, the key generated
+// for requests when Client.IdempotencyKeys is enabled.
+func NewIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("restclient: cannot read random bytes: " + err.Error())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// needsIdempotencyKey - reports whether method is one that isn't
+// idempotent by definition, so needs a key to be retried safely.
+func needsIdempotencyKey(method string) bool {
+	return strings.EqualFold(method, http.MethodPost) || strings.EqualFold(method, http.MethodPatch)
+}
+
+// setIdempotencyKey - sets the Idempotency-Key header on req, unless the
+// caller already did, from the context or, with IdempotencyKeys enabled,
+// a freshly generated key for POST and PATCH.  This happens once in
+// NewRequest, so every attempt at the request carries the same key.
+func (cl *Client) setIdempotencyKey(req *http.Request) {
+	if req.Header.Get(IdempotencyKeyHeader) != "" {
+		return
+	}
+	key := IdempotencyKeyFromContext(req.Context())
+	if key == "" && cl.IdempotencyKeys && needsIdempotencyKey(req.Method) {
+		key = NewIdempotencyKey()
+	}
+	if key != "" {
+		req.Header[IdempotencyKeyHeader] = []string{key}
+	}
+}
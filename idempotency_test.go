@@ -0,0 +1,76 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestIdempotencyKeys(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		n := len(keys)
+		mu.Unlock()
+		if n%3 != 0 {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(201)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{IdempotencyKeys: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	if err = cl.Post(context.Background(), su, "/orders", nil, &testResponse{}, nil); err != nil {
+		t.Fatal("expected POST with idempotency key to be retried, got ", err)
+	}
+	if len(keys) != 3 || !uuidV4.MatchString(keys[0]) || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Log("expected one generated key reused across attempts, got ", keys)
+		t.Fail()
+	}
+
+	ctx := WithIdempotencyKey(context.Background(), "order-59")
+	if err = cl.Post(ctx, su, "/orders", nil, &testResponse{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if keys[3] != "order-59" || keys[5] != "order-59" {
+		t.Log("expected caller supplied key, got ", keys[3:])
+		t.Fail()
+	}
+
+	if err = cl.Put(ctx, su, "/orders/59", nil, &testResponse{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.Get(context.Background(), su, "/orders/59", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if keys[6] != "order-59" || keys[9] != "" {
+		t.Log("expected keys only where supplied or needed, got ", keys[6:])
+		t.Fail()
+	}
+
+	// Without a key, POST is still only tried once.
+	cl.IdempotencyKeys = false
+	keys = nil
+	if err = cl.Post(context.Background(), su, "/orders", nil, &testResponse{}, nil); StatusCode(err) != 503 || len(keys) != 1 {
+		t.Logf("expected single attempt without key, got %v %v", keys, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,230 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter - decides when a request may be sent.  Set one on Client.Limiter
+// to cap the requests in flight.  Acquire is called before every attempt
+// and either admits the request, returning a func that must be called
+// exactly once with the outcome when the attempt is over, or refuses it
+// with an error, normally a *RejectedError.  The outcome lets limiters
+// that adapt to the backend see how it is coping.
+type Limiter interface {
+	Acquire(req *http.Request) (release func(Outcome), err error)
+}
+
+// Outcome - how an admitted request went.
+type Outcome struct {
+	// Latency - the time from being admitted until the response body was
+	// read, or the request failed.
+	Latency time.Duration
+
+	// StatusCode - the response status, or 0 if there was no response.
+	StatusCode int
+
+	// Err - the transport error, if the request got no response.
+	Err error
+}
+
+// Overloaded - reports whether the outcome suggests the backend is
+// overloaded: a timeout, or a 429, 503 or 504 response.
+func (o Outcome) Overloaded() bool {
+	switch o.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return o.Err != nil && IsTimeout(o.Err)
+}
+
+// RejectedError - returned, wrapped in a RequestError, when a Limiter
+// refuses a request.  The request was never sent.
+type RejectedError struct {
+	// Key - what the limit applies to, the request host for per host
+	// limits and "" otherwise.
+	Key string
+
+	// Reason - why the request was refused.
+	Reason string
+
+	// Limit - the concurrency limit in force.
+	Limit int
+
+	// Waited - how long the request queued before being refused.
+	Waited time.Duration
+}
+
+func (e *RejectedError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("request rejected by limiter for %s (limit %d): %s", e.Key, e.Limit, e.Reason)
+	}
+	return fmt.Sprintf("request rejected by limiter (limit %d): %s", e.Limit, e.Reason)
+}
+
+// IsRejected - reports whether err is a request refused by a Limiter.
+func IsRejected(err error) bool {
+	var re *RejectedError
+	return errors.As(err, &re)
+}
+
+// Bulkhead - a Limiter allowing at most MaxConcurrent requests in flight,
+// either per host or for the whole client, so that one slow dependency
+// can't tie up every goroutine and socket.  Requests over the limit queue
+// for a slot, up to MaxQueue of them for at most QueueTimeout; anything
+// beyond that is rejected at once.
+type Bulkhead struct {
+	// MaxConcurrent - the number of requests allowed in flight.  Values
+	// <= 0 mean 1.
+	MaxConcurrent int
+
+	// MaxQueue - how many requests may wait for a slot.  Zero means none
+	// do, so requests over the limit are rejected immediately.
+	MaxQueue int
+
+	// QueueTimeout - how long a request may wait for a slot.  Zero means
+	// it waits as long as its context allows.
+	QueueTimeout time.Duration
+
+	// PerHost - setting this to true applies the limits to each request
+	// host separately, rather than to everything sent through the client.
+	PerHost bool
+
+	mu    sync.Mutex
+	parts map[string]*compartment
+}
+
+// BulkheadStats - a snapshot of one compartment of a Bulkhead.
+type BulkheadStats struct {
+	InFlight int
+	Queued   int
+	Admitted uint64
+	Rejected uint64
+
+	// TotalWait, MaxWait - time spent queueing by admitted requests.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// compartment - the slots for one key of a Bulkhead.
+type compartment struct {
+	slots chan struct{}
+	stats BulkheadStats
+}
+
+// Acquire - implements Limiter.
+func (b *Bulkhead) Acquire(req *http.Request) (func(Outcome), error) {
+	key := ""
+	if b.PerHost {
+		key = req.URL.Host
+	}
+	limit := b.MaxConcurrent
+	if limit <= 0 {
+		limit = 1
+	}
+
+	b.mu.Lock()
+	if b.parts == nil {
+		b.parts = make(map[string]*compartment)
+	}
+	c, ok := b.parts[key]
+	if !ok {
+		c = &compartment{slots: make(chan struct{}, limit)}
+		b.parts[key] = c
+	}
+	select {
+	case c.slots <- struct{}{}:
+		c.stats.InFlight++
+		c.stats.Admitted++
+		b.mu.Unlock()
+		return b.releaser(c), nil
+	default:
+	}
+	if c.stats.Queued >= b.MaxQueue {
+		c.stats.Rejected++
+		b.mu.Unlock()
+		return nil, &RejectedError{Key: key, Reason: "queue full", Limit: limit}
+	}
+	c.stats.Queued++
+	b.mu.Unlock()
+
+	ctx := req.Context()
+	if b.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.QueueTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	select {
+	case c.slots <- struct{}{}:
+		waited := time.Since(start)
+		b.mu.Lock()
+		c.stats.Queued--
+		c.stats.InFlight++
+		c.stats.Admitted++
+		c.stats.TotalWait += waited
+		if waited > c.stats.MaxWait {
+			c.stats.MaxWait = waited
+		}
+		b.mu.Unlock()
+		return b.releaser(c), nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		c.stats.Queued--
+		c.stats.Rejected++
+		b.mu.Unlock()
+		if err := req.Context().Err(); err != nil {
+			// The caller gave up, that's not the limiter's doing.
+			return nil, err
+		}
+		return nil, &RejectedError{Key: key, Reason: "queue timeout", Limit: limit, Waited: time.Since(start)}
+	}
+}
+
+func (b *Bulkhead) releaser(c *compartment) func(Outcome) {
+	var once sync.Once
+	return func(Outcome) {
+		once.Do(func() {
+			b.mu.Lock()
+			c.stats.InFlight--
+			b.mu.Unlock()
+			<-c.slots
+		})
+	}
+}
+
+// Stats - returns a snapshot of every compartment, keyed by host when
+// PerHost is set and by "" otherwise.
+func (b *Bulkhead) Stats() map[string]BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make(map[string]BulkheadStats, len(b.parts))
+	for k, c := range b.parts {
+		out[k] = c.stats
+	}
+	return out
+}
+
+// acquire - asks Client.Limiter to admit req.  The returned func reports
+// the outcome and is safe to call when there is no limiter.
+func (cl *Client) acquire(req *http.Request) (func(resp *http.Response, err error), error) {
+	if cl.Limiter == nil {
+		return func(*http.Response, error) {}, nil
+	}
+	release, err := cl.Limiter.Acquire(req)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	return func(resp *http.Response, err error) {
+		o := Outcome{Latency: time.Since(start), Err: err}
+		if resp != nil {
+			o.StatusCode = resp.StatusCode
+		}
+		release(o)
+	}, nil
+}
@@ -0,0 +1,117 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bh := &Bulkhead{MaxConcurrent: 2, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond}
+	cl.Limiter = bh
+	cl.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	cl.HAR = &HARRecorder{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl.Get(context.Background(), su, "/slow", nil, nil)
+		}()
+	}
+	waitFor(t, func() bool { return bh.Stats()[""].InFlight == 2 })
+
+	// The third request queues and times out, the fourth finds the queue
+	// full.
+	queued := make(chan error, 1)
+	go func() { queued <- cl.Get(context.Background(), su, "/slow", nil, nil) }()
+	waitFor(t, func() bool { return bh.Stats()[""].Queued == 1 })
+	err = cl.Get(context.Background(), su, "/slow", nil, nil)
+	var re *RejectedError
+	if !errors.As(err, &re) || re.Reason != "queue full" || re.Limit != 2 || IsRetryable(err) {
+		t.Log("expected queue full rejection, got ", err)
+		t.Fail()
+	}
+	err = <-queued
+	if !errors.As(err, &re) || re.Reason != "queue timeout" || re.Waited < 50*time.Millisecond {
+		t.Log("expected queue timeout rejection, got ", err)
+		t.Fail()
+	}
+
+	close(release)
+	wg.Wait()
+	st := bh.Stats()[""]
+	if st.InFlight != 0 || st.Queued != 0 || st.Admitted != 2 || st.Rejected != 2 {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+	if entries := cl.HAR.Entries(); len(entries) != 2 {
+		t.Log("expected only the admitted requests to be recorded, got ", len(entries))
+		t.Fail()
+	}
+}
+
+func TestBulkheadPerHost(t *testing.T) {
+	bh := &Bulkhead{MaxConcurrent: 1, MaxQueue: 1, PerHost: true}
+	a, _ := http.NewRequest("GET", "http://a.example.com/", nil)
+	b, _ := http.NewRequest("GET", "http://b.example.com/", nil)
+
+	releaseA, err := bh.Acquire(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseB, err := bh.Acquire(b)
+	if err != nil {
+		t.Fatal("expected separate limit per host, got ", err)
+	}
+	releaseB(Outcome{})
+
+	done := make(chan error, 1)
+	go func() {
+		release, err := bh.Acquire(a)
+		if err == nil {
+			release(Outcome{})
+		}
+		done <- err
+	}()
+	waitFor(t, func() bool { return bh.Stats()["a.example.com"].Queued == 1 })
+	time.Sleep(10 * time.Millisecond)
+	releaseA(Outcome{})
+	releaseA(Outcome{})
+	if err := <-done; err != nil {
+		t.Fatal("expected queued request to be admitted, got ", err)
+	}
+	if st := bh.Stats()["a.example.com"]; st.Admitted != 2 || st.MaxWait < 10*time.Millisecond || st.InFlight != 0 {
+		t.Logf("unexpected stats %#v", st)
+		t.Fail()
+	}
+}
+
+// waitFor - polls cond for up to a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,300 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultPollInterval    = time.Second
+	defaultMaxPollInterval = 30 * time.Second
+)
+
+// PollOptions - controls BaseClient.ReqAndPoll.  The zero value follows the
+// common conventions described there.
+type PollOptions struct {
+	// Interval - the delay before the first poll, doubling after each
+	// one, used whenever the server doesn't send Retry-After.  Zero means
+	// 1s.  A Retry-After is used up to MaxInterval.
+	Interval time.Duration
+
+	// MaxInterval - the cap on the delay between polls.  Zero means 30s.
+	MaxInterval time.Duration
+
+	// OperationURL - works out the status URL from the 202 response, for
+	// APIs that return an operation id rather than a Location.  Like a
+	// Location, the URL may be relative to the URL the request was sent
+	// to.  nil means the standard headers and fields are used.
+	OperationURL func(resp *http.Response, body []byte) (string, error)
+
+	// Done - decides from a status response whether the operation has
+	// finished, returning an error if it finished unsuccessfully.  nil
+	// means the standard status fields are used.
+	Done func(resp *http.Response, body []byte) (bool, error)
+}
+
+// OperationError - returned, wrapped in a RequestError, when a
+// long-running operation finishes unsuccessfully.
+type OperationError struct {
+	// Status - the terminal status reported, e.g. "failed".
+	Status string
+
+	// Body - the final status response body.
+	Body []byte
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("long-running operation finished with status %q", e.Status)
+}
+
+// operationStatus - the standard fields of a status resource.
+type operationStatus struct {
+	Status           string          `json:"status"`
+	State            string          `json:"state"`
+	Done             *bool           `json:"done"`
+	Error            json.RawMessage `json:"error"`
+	ResourceLocation string          `json:"resourceLocation"`
+	StatusURL        string          `json:"statusUrl"`
+	StatusURLSnake   string          `json:"status_url"`
+	Href             string          `json:"href"`
+}
+
+// ReqAndPoll - sends a request that may be completed asynchronously.  If
+// the server answers anything but 202 Accepted, the response is handled
+// exactly as by ReqWithHeaders.  Otherwise the operation's status URL is
+// polled with GET until it reaches a terminal state, waiting as long as
+// Retry-After says or backing off otherwise, and the result is decoded
+// into responseBody.  Everything is bounded by ctx.
+//
+// By default the status URL is taken from the Location or
+// Operation-Location header, or a statusUrl, status_url or href field of a
+// json body.  A status response is still running when it is a 202, or
+// when its json status or state field isn't one of succeeded, success,
+// completed, complete, done or finished, or failed, failure, error,
+// canceled or cancelled, which end it with an *OperationError.  A
+// google-style done field, with an error field on failure, is understood
+// too, and any other 2xx response counts as finished.  The result is
+// fetched from the final response's Location header or resourceLocation
+// field, if it has one, and is otherwise the final response body itself.
+// Relative status and result URLs are resolved against the URL of the
+// response they came in.  Client.FixupCallback and per-request credentials
+// are only applied to status and result URLs on the same scheme and host as
+// the original request, so they aren't handed to another server.  When
+// responseBody is a proto.Message, the first
+// request and the result fetch ask for it as ReqWithHeaders would, while
+// the status polls leave Accept alone so that their fields can be read.
+func (bc *BaseClient) ReqAndPoll(ctx context.Context, method, path string, queryStruct,
+	requestBody interface{}, responseBody interface{}, opts *PollOptions) (*http.Response, error) {
+	if opts == nil {
+		opts = &PollOptions{}
+	}
+	cl := bc.Client
+	var headers http.Header
+	if _, ok := responseBody.(proto.Message); ok && cl.codec(optionsFromContext(ctx)) == nil {
+		// rawBody hides responseBody from ReqWithHeaders.
+		headers = http.Header{"Accept": {cl.protoMediaType()}}
+	}
+	raw := &rawBody{}
+	resp, err := bc.ReqWithHeaders(ctx, method, path, queryStruct, requestBody, raw, headers)
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		if err != nil {
+			return resp, err
+		}
+		return resp, bc.decodeRaw(resp, raw.b, responseBody)
+	}
+
+	statusURL, err := operationURL(resp, raw.b, opts)
+	if err != nil {
+		return resp, cl.requestError(method, resp.Request.URL.String(), "", 1, PhaseDecode, err)
+	}
+	statusURL = resolveReference(resp, statusURL)
+	origin := resp.Request.URL
+	pollCtx := ctx
+	if !sameOrigin(origin, statusURL) {
+		pollCtx = withoutFixups(ctx)
+	}
+
+	delay := opts.Interval
+	if delay <= 0 {
+		delay = defaultPollInterval
+	}
+	maxDelay := opts.MaxInterval
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxPollInterval
+	}
+	for poll := 1; ; poll++ {
+		wait := delay
+		if d, ok := RetryAfter(resp.Header); ok {
+			wait = d
+			if wait > maxDelay {
+				wait = maxDelay
+			}
+		} else if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+		if err = sleepContext(ctx, wait); err != nil {
+			return resp, cl.requestError(http.MethodGet, statusURL, "", poll, PhaseTransport, err)
+		}
+
+		raw = &rawBody{}
+		resp, err = bc.ReqWithHeaders(pollCtx, http.MethodGet, statusURL, nil, nil, raw, nil)
+		if err != nil {
+			return resp, err
+		}
+		done, err := operationDone(resp, raw.b, opts)
+		if err != nil {
+			return resp, cl.requestError(http.MethodGet, statusURL, "", poll, PhaseStatus, err)
+		}
+		if !done {
+			continue
+		}
+
+		if result := resultURL(resp, raw.b); result != "" && result != statusURL {
+			result = resolveReference(resp, result)
+			resultCtx := ctx
+			if !sameOrigin(origin, result) {
+				resultCtx = withoutFixups(ctx)
+			}
+			return bc.ReqWithHeaders(resultCtx, http.MethodGet, result, nil, nil, responseBody, nil)
+		}
+		return resp, bc.decodeRaw(resp, raw.b, responseBody)
+	}
+}
+
+// decodeRaw - decodes a body captured with rawBody into responseBody.
+func (bc *BaseClient) decodeRaw(resp *http.Response, body []byte, responseBody interface{}) error {
+	if isNil(responseBody) || len(body) == 0 {
+		return nil
+	}
+	if err := bc.Client.decodeBody(resp, bytes.NewReader(body), responseBody); err != nil {
+		return bc.Client.requestError(resp.Request.Method, resp.Request.URL.String(), "", 1, PhaseDecode, err)
+	}
+	return nil
+}
+
+// operationURL - finds the status URL in a 202 response.
+func operationURL(resp *http.Response, body []byte, opts *PollOptions) (string, error) {
+	if opts.OperationURL != nil {
+		return opts.OperationURL(resp, body)
+	}
+	for _, h := range []string{"Location", "Operation-Location"} {
+		if v := resp.Header.Get(h); v != "" {
+			return v, nil
+		}
+	}
+	var st operationStatus
+	if json.Unmarshal(body, &st) == nil {
+		for _, v := range []string{st.StatusURL, st.StatusURLSnake, st.Href} {
+			if v != "" {
+				return v, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("202 response has no Location header or status URL")
+}
+
+// operationDone - decides whether a status response is terminal.
+func operationDone(resp *http.Response, body []byte, opts *PollOptions) (bool, error) {
+	if opts.Done != nil {
+		return opts.Done(resp, body)
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return false, nil
+	}
+	var st operationStatus
+	if json.Unmarshal(body, &st) != nil {
+		return true, nil
+	}
+	if st.Done != nil {
+		if *st.Done && len(st.Error) > 0 && string(st.Error) != "null" {
+			return true, &OperationError{Status: "error", Body: body}
+		}
+		return *st.Done, nil
+	}
+	status := st.Status
+	if status == "" {
+		status = st.State
+	}
+	switch strings.ToLower(status) {
+	case "":
+		return true, nil
+	case "succeeded", "success", "completed", "complete", "done", "finished":
+		return true, nil
+	case "failed", "failure", "error", "canceled", "cancelled":
+		return true, &OperationError{Status: status, Body: body}
+	}
+	return false, nil
+}
+
+// resultURL - where the result of a finished operation lives, if not in
+// the final status response itself.
+func resultURL(resp *http.Response, body []byte) string {
+	if v := resp.Header.Get("Location"); v != "" {
+		return v
+	}
+	var st operationStatus
+	if json.Unmarshal(body, &st) == nil {
+		return st.ResourceLocation
+	}
+	return ""
+}
+
+// resolveReference - resolves ref against the URL resp was fetched from.
+func resolveReference(resp *http.Response, ref string) string {
+	if resp.Request == nil {
+		return ref
+	}
+	u, err := resp.Request.URL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// sameOrigin - reports whether rawURL has the same scheme and host as u.
+func sameOrigin(u *url.URL, rawURL string) bool {
+	v, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if v.Host == "" {
+		// Still relative, so resolved against the BaseURL.
+		return true
+	}
+	return strings.EqualFold(u.Scheme, v.Scheme) && strings.EqualFold(u.Host, v.Host)
+}
+
+type noFixupsKey struct{}
+
+// withoutFixups - returns a copy of ctx on which requests are built
+// without Client.FixupCallback or per-request credentials.
+func withoutFixups(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noFixupsKey{}, true)
+}
+
+// fixupsAllowed - reports whether requests built with ctx get
+// Client.FixupCallback and per-request credentials.
+func fixupsAllowed(ctx context.Context) bool {
+	skip, _ := ctx.Value(noFixupsKey{}).(bool)
+	return !skip
+}
+
+// sleepContext - waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,233 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newPollServer(t *testing.T, final string) (*httptest.Server, *int32) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/jobs":
+			w.Header().Set("Location", "/jobs/1/status")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusAccepted)
+		case "/jobs/1/status":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				fmt.Fprint(w, `{"status":"running"}`)
+				return
+			}
+			fmt.Fprint(w, final)
+		case "/things/1":
+			fmt.Fprint(w, `{"foo":"done"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv, &polls
+}
+
+func TestReqAndPoll(t *testing.T) {
+	srv, polls := newPollServer(t, `{"status":"Succeeded","resourceLocation":"/things/1"}`)
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	var out testResponse
+	resp, err := bc.ReqAndPoll(context.Background(), "POST", "/jobs", nil, &testLogin{User: "u"}, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Foo != "done" || resp.Request.URL.Path != "/things/1" {
+		t.Logf("unexpected result %#v from %s", out, resp.Request.URL)
+		t.Fail()
+	}
+	if atomic.LoadInt32(polls) != 3 {
+		t.Log("expected 3 polls, got ", atomic.LoadInt32(polls))
+		t.Fail()
+	}
+}
+
+func TestReqAndPollFailed(t *testing.T) {
+	srv, _ := newPollServer(t, `{"state":"failed","message":"boom"}`)
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, _ := NewClient(nil, nil)
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	_, err := bc.ReqAndPoll(context.Background(), "POST", "/jobs", nil, nil, &testResponse{}, nil)
+	var oe *OperationError
+	var re *RequestError
+	if !errors.As(err, &oe) || oe.Status != "failed" || !errors.As(err, &re) || re.Phase != PhaseStatus {
+		t.Log("expected operation error, got ", err)
+		t.Fail()
+	}
+}
+
+func TestReqAndPollCustom(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/jobs":
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"id":"42"}`)
+		case "/operations/42":
+			fmt.Fprint(w, `{"phase":"READY","foo":"custom"}`)
+		}
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, _ := NewClient(nil, nil)
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	opts := &PollOptions{
+		Interval: time.Millisecond,
+		OperationURL: func(resp *http.Response, body []byte) (string, error) {
+			var op struct{ ID string }
+			if err := json.Unmarshal(body, &op); err != nil {
+				return "", err
+			}
+			return "/operations/" + op.ID, nil
+		},
+		Done: func(resp *http.Response, body []byte) (bool, error) {
+			var op struct{ Phase string }
+			err := json.Unmarshal(body, &op)
+			return op.Phase == "READY", err
+		},
+	}
+	var out testResponse
+	if _, err := bc.ReqAndPoll(context.Background(), "POST", "/jobs", nil, nil, &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if out.Foo != "custom" {
+		t.Logf("unexpected result %#v", out)
+		t.Fail()
+	}
+}
+
+func TestReqAndPollSync(t *testing.T) {
+	srv, polls := newPollServer(t, "")
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, _ := NewClient(nil, nil)
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	var out testResponse
+	if _, err := bc.ReqAndPoll(context.Background(), "GET", "/things/1", nil, nil, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.Foo != "done" || atomic.LoadInt32(polls) != 0 {
+		t.Logf("expected a synchronous result, got %#v", out)
+		t.Fail()
+	}
+}
+
+func TestReqAndPollContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/status")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, _ := NewClient(nil, nil)
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := bc.ReqAndPoll(ctx, "POST", "/jobs", nil, nil, nil, &PollOptions{Interval: 5 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Log("expected the deadline to end polling, got ", err)
+		t.Fail()
+	}
+}
+
+func TestReqAndPollProto(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != mediaTypeProtobuf {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		b, _ := proto.Marshal(wrapperspb.String("done"))
+		w.Header().Set("Content-Type", mediaTypeProtobuf)
+		w.Write(b)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	out := &wrapperspb.StringValue{}
+	if _, err = bc.ReqAndPoll(context.Background(), "POST", "/jobs", nil, nil, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.Value != "done" {
+		t.Logf("unexpected result %#v", out)
+		t.Fail()
+	}
+}
+
+func TestReqAndPollOtherHost(t *testing.T) {
+	var statusAuth atomic.Value
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusAuth.Store(r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"status":"succeeded","foo":"done"}`)
+	}))
+	defer status.Close()
+	var jobAuth string
+	jobs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jobAuth = r.Header.Get("Authorization")
+		w.Header().Set("Location", status.URL+"/ops/1")
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer jobs.Close()
+	su, _ := url.Parse(jobs.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.FixupCallback = func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer secret")
+		return nil
+	}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	var out testResponse
+	start := time.Now()
+	_, err = bc.ReqAndPoll(context.Background(), "POST", "/jobs", nil, nil, &out,
+		&PollOptions{MaxInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Log("expected Retry-After to be capped by MaxInterval, took ", d)
+		t.Fail()
+	}
+	if jobAuth != "Bearer secret" || statusAuth.Load() != "" || out.Foo != "done" {
+		t.Logf("expected credentials for the original host only, got %q and %q, result %#v",
+			jobAuth, statusAuth.Load(), out)
+		t.Fail()
+	}
+}
@@ -0,0 +1,495 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeTimeout  = 5 * time.Second
+)
+
+// Strategy - how a MultiClient picks an endpoint for each request.
+type Strategy int
+
+const (
+	// RoundRobin - cycle through the healthy endpoints in turn.
+	RoundRobin Strategy = iota
+
+	// Random - pick a healthy endpoint at random, in proportion to its
+	// Weight.
+	Random
+
+	// LeastOutstanding - pick the healthy endpoint with the fewest
+	// requests in flight.
+	LeastOutstanding
+
+	// PriorityFailover - use the healthy endpoints with the lowest
+	// Priority, picking among them at random in proportion to Weight, and
+	// only move on to the next Priority when all of those are unhealthy.
+	// This is the selection rule for DNS SRV records.
+	PriorityFailover
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case Random:
+		return "random"
+	case LeastOutstanding:
+		return "least-outstanding"
+	case PriorityFailover:
+		return "priority-failover"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// Endpoint - a base URL that a MultiClient can send requests to.
+type Endpoint struct {
+	URL *url.URL
+
+	// Priority - lower is preferred, see PriorityFailover.
+	Priority int
+
+	// Weight - the relative share of requests among endpoints of the same
+	// priority, see Random and PriorityFailover.  Zero counts as 1.
+	Weight int
+}
+
+// EndpointSource - supplies the endpoints a MultiClient balances across.
+// It is asked for the current list on every request, so implementations
+// that look endpoints up elsewhere should cache them, and should keep
+// returning the last good list when a refresh fails.
+type EndpointSource interface {
+	Endpoints(ctx context.Context) ([]Endpoint, error)
+}
+
+// StaticEndpoints - a fixed list of endpoints.
+type StaticEndpoints []Endpoint
+
+// Endpoints - implements EndpointSource.
+func (se StaticEndpoints) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	return se, nil
+}
+
+// ParseEndpoints - parses baseURLs into StaticEndpoints, with priorities in
+// the order given, so PriorityFailover prefers the first.
+func ParseEndpoints(baseURLs ...string) (StaticEndpoints, error) {
+	se := make(StaticEndpoints, len(baseURLs))
+	for i, raw := range baseURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		se[i] = Endpoint{URL: u, Priority: i, Weight: 1}
+	}
+	return se, nil
+}
+
+// EndpointState - a snapshot of how a MultiClient sees an endpoint.
+type EndpointState struct {
+	Endpoint
+
+	// Healthy - false once the endpoint has failed FailureThreshold times
+	// in a row, until a health check succeeds.
+	Healthy bool
+
+	// Outstanding - requests currently in flight to the endpoint.
+	Outstanding int
+
+	// Failures - consecutive failed requests.
+	Failures int
+
+	// LastError - the most recent failure, if any.
+	LastError string
+
+	// UnhealthySince - when the endpoint was marked unhealthy.
+	UnhealthySince time.Time
+}
+
+// MultiClient - like BaseClient, but spreads requests over several base
+// URLs.  An endpoint that returns transport errors or 5xx responses
+// FailureThreshold times in a row is marked unhealthy and skipped until a
+// health check brings it back; probes run in the background, no more
+// often than ProbeInterval, when a request finds an unhealthy endpoint due
+// for one.  If every endpoint is unhealthy, requests are sent to them
+// anyway rather than failing outright.
+//
+// A request that fails in a way that marks its endpoint is sent on to the
+// next endpoint, as long as that is safe: for idempotent methods, and for
+// POST and PATCH carrying an Idempotency-Key.  Any Client.Retry policy
+// applies to each endpoint separately.
+type MultiClient struct {
+	Client *Client
+	Source EndpointSource
+
+	// Strategy - how endpoints are picked.  The default is RoundRobin.
+	Strategy Strategy
+
+	// FailureThreshold - consecutive failures before an endpoint is marked
+	// unhealthy.  Zero means 1.
+	FailureThreshold int
+
+	// HealthCheckPath - the path, relative to an endpoint's URL, that is
+	// fetched with GET to check whether an unhealthy endpoint has
+	// recovered.  Any status below 400 counts as healthy.  Empty means
+	// unhealthy endpoints are simply given another chance after
+	// ProbeInterval.
+	HealthCheckPath string
+
+	// ProbeInterval - the minimum time between health checks of an
+	// endpoint.  Zero means 10s.
+	ProbeInterval time.Duration
+
+	// ProbeTimeout - the timeout for each health check.  Zero means 5s.
+	ProbeTimeout time.Duration
+
+	mu     sync.Mutex
+	states map[string]*endpointState
+	order  []string
+	next   int
+}
+
+// endpointState - the mutable state behind EndpointState.
+type endpointState struct {
+	EndpointState
+	key       string
+	nextProbe time.Time
+	probing   bool
+}
+
+// NewMultiClient - create a MultiClient balancing over baseURLs, see
+// ParseEndpoints.
+func NewMultiClient(baseURLs []string, cfg *ClientConfig, transport http.RoundTripper) (*MultiClient, error) {
+	se, err := ParseEndpoints(baseURLs...)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiClient{
+		Client: client,
+		Source: se,
+	}, nil
+}
+
+// Endpoints - returns the state of every known endpoint, in the order the
+// source last listed them.
+func (mc *MultiClient) Endpoints() []EndpointState {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	out := make([]EndpointState, 0, len(mc.order))
+	for _, key := range mc.order {
+		out = append(out, mc.states[key].EndpointState)
+	}
+	return out
+}
+
+// Get - like BaseClient.Get.
+func (mc *MultiClient) Get(ctx context.Context, path string, queryStruct interface{}, responseBody interface{}) error {
+	_, err := mc.Req(ctx, "GET", path, queryStruct, nil, responseBody)
+	return err
+}
+
+// Delete - like BaseClient.Delete.
+func (mc *MultiClient) Delete(ctx context.Context, path string, queryStruct interface{}, responseBody interface{}) error {
+	_, err := mc.Req(ctx, "DELETE", path, queryStruct, nil, responseBody)
+	return err
+}
+
+// Post - like BaseClient.Post.
+func (mc *MultiClient) Post(ctx context.Context, path string, queryStruct, requestBody interface{}, responseBody interface{}) error {
+	_, err := mc.Req(ctx, "POST", path, queryStruct, requestBody, responseBody)
+	return err
+}
+
+// Put - like BaseClient.Put.
+func (mc *MultiClient) Put(ctx context.Context, path string, queryStruct, requestBody interface{}, responseBody interface{}) error {
+	_, err := mc.Req(ctx, "PUT", path, queryStruct, requestBody, responseBody)
+	return err
+}
+
+// Req - like BaseClient.Req.
+func (mc *MultiClient) Req(ctx context.Context, method, path string, queryStruct,
+	requestBody interface{}, responseBody interface{}) (*http.Response, error) {
+	return mc.ReqWithHeaders(ctx, method, path, queryStruct, requestBody, responseBody, nil)
+}
+
+// ReqWithHeaders - like BaseClient.ReqWithHeaders, with the base URL
+// picked by the Strategy, failing over to other endpoints as described for
+// MultiClient.
+func (mc *MultiClient) ReqWithHeaders(ctx context.Context, method, path string, queryStruct,
+	requestBody interface{}, responseBody interface{}, headers http.Header) (*http.Response, error) {
+	// Every endpoint must see the same key, or failing over could create
+	// duplicates.
+	if mc.Client.IdempotencyKeys && needsIdempotencyKey(method) &&
+		IdempotencyKeyFromContext(ctx) == "" && headers.Get(IdempotencyKeyHeader) == "" {
+		ctx = WithIdempotencyKey(ctx, NewIdempotencyKey())
+	}
+	canFailover := !needsIdempotencyKey(method) ||
+		IdempotencyKeyFromContext(ctx) != "" || headers.Get(IdempotencyKeyHeader) != ""
+
+	tried := make(map[string]bool)
+	var (
+		lastResp *http.Response
+		lastErr  error
+	)
+	for {
+		st, err := mc.pick(ctx, tried)
+		if err != nil {
+			return nil, mc.Client.requestError(method, path, "", 1, PhaseTransport, err)
+		}
+		if st == nil {
+			return lastResp, lastErr
+		}
+		tried[st.key] = true
+		resp, err := mc.Client.ReqWithHeaders(ctx, st.URL, method, path, queryStruct, requestBody, responseBody, headers)
+		failed := endpointFailed(ctx, err)
+		mc.release(st, err, failed)
+		if !failed || !canFailover {
+			return resp, err
+		}
+		lastResp, lastErr = resp, err
+	}
+}
+
+// errNoEndpoints - returned when the source has no endpoints at all.
+var errNoEndpoints = errors.New("restclient: no endpoints available")
+
+// pick - chooses an endpoint not yet tried for this request, counting it
+// as outstanding.  It returns nil once every endpoint has been tried.
+func (mc *MultiClient) pick(ctx context.Context, tried map[string]bool) (*endpointState, error) {
+	eps, err := mc.Source.Endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(eps) == 0 {
+		return nil, errNoEndpoints
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.sync(eps)
+
+	now := time.Now()
+	var healthy, untried []*endpointState
+	for _, key := range mc.order {
+		st := mc.states[key]
+		if tried[key] {
+			continue
+		}
+		if !st.Healthy && !st.probing && !now.Before(st.nextProbe) {
+			mc.probe(st)
+		}
+		untried = append(untried, st)
+		if st.Healthy {
+			healthy = append(healthy, st)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var st *endpointState
+	switch mc.Strategy {
+	case Random:
+		st = pickWeighted(candidates)
+	case LeastOutstanding:
+		for _, c := range rotate(candidates, mc.next) {
+			if st == nil || c.Outstanding < st.Outstanding {
+				st = c
+			}
+		}
+		mc.next++
+	case PriorityFailover:
+		var best []*endpointState
+		for _, c := range candidates {
+			switch {
+			case len(best) == 0 || c.Priority < best[0].Priority:
+				best = []*endpointState{c}
+			case c.Priority == best[0].Priority:
+				best = append(best, c)
+			}
+		}
+		st = pickWeighted(best)
+	default:
+		st = candidates[mc.next%len(candidates)]
+		mc.next++
+	}
+	st.Outstanding++
+	return st, nil
+}
+
+// sync - brings the endpoint states in line with the source's list,
+// keeping the state of endpoints that are still listed.
+func (mc *MultiClient) sync(eps []Endpoint) {
+	if mc.states == nil {
+		mc.states = make(map[string]*endpointState)
+	}
+	order := make([]string, 0, len(eps))
+	seen := make(map[string]bool, len(eps))
+	for _, ep := range eps {
+		key := ep.URL.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		order = append(order, key)
+		st, ok := mc.states[key]
+		if !ok {
+			st = &endpointState{key: key}
+			st.Healthy = true
+			mc.states[key] = st
+		}
+		st.Endpoint = ep
+	}
+	for key := range mc.states {
+		if !seen[key] {
+			delete(mc.states, key)
+		}
+	}
+	mc.order = order
+}
+
+// release - records the outcome of a request to st.
+func (mc *MultiClient) release(st *endpointState, err error, failed bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	st.Outstanding--
+	if !failed {
+		st.Failures = 0
+		return
+	}
+	st.Failures++
+	st.LastError = err.Error()
+	threshold := mc.FailureThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if st.Healthy && st.Failures >= threshold {
+		st.Healthy = false
+		st.UnhealthySince = time.Now()
+		st.nextProbe = st.UnhealthySince.Add(mc.probeInterval())
+	}
+}
+
+// probe - checks whether st has recovered.  Called with mc.mu held.
+func (mc *MultiClient) probe(st *endpointState) {
+	if mc.HealthCheckPath == "" {
+		st.Healthy = true
+		st.Failures = 0
+		return
+	}
+	st.probing = true
+	target, err := JoinURL(st.URL, mc.HealthCheckPath)
+	go func() {
+		ok := err == nil && mc.checkHealth(target)
+		mc.mu.Lock()
+		defer mc.mu.Unlock()
+		st.probing = false
+		if ok {
+			st.Healthy = true
+			st.Failures = 0
+			return
+		}
+		st.nextProbe = time.Now().Add(mc.probeInterval())
+	}()
+}
+
+func (mc *MultiClient) checkHealth(target *url.URL) bool {
+	timeout := mc.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := mc.Client.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+func (mc *MultiClient) probeInterval() time.Duration {
+	if mc.ProbeInterval > 0 {
+		return mc.ProbeInterval
+	}
+	return defaultProbeInterval
+}
+
+// endpointFailed - reports whether err says something about the endpoint,
+// rather than the request or the caller giving up.
+func endpointFailed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || IsRejected(err) {
+		return false
+	}
+	var rqe *RequestError
+	if !errors.As(err, &rqe) {
+		return false
+	}
+	return rqe.Phase == PhaseTransport || StatusCode(err) >= 500
+}
+
+// pickWeighted - picks one of candidates at random, in proportion to
+// Weight.
+func pickWeighted(candidates []*endpointState) *endpointState {
+	total := 0
+	for _, c := range candidates {
+		total += weight(c.Weight)
+	}
+	n := rand.Intn(total)
+	for _, c := range candidates {
+		n -= weight(c.Weight)
+		if n < 0 {
+			return c
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func weight(w int) int {
+	if w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// rotate - returns s starting at index n, so ties don't always go to the
+// first endpoint.
+func rotate(s []*endpointState, n int) []*endpointState {
+	n %= len(s)
+	return append(append([]*endpointState(nil), s[n:]...), s[:n]...)
+}
+
+// String - describes the endpoint state, for logging.
+func (es EndpointState) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s priority=%d weight=%d outstanding=%d", es.URL, es.Priority, weight(es.Weight), es.Outstanding)
+	if !es.Healthy {
+		fmt.Fprintf(&b, " unhealthy since %s: %s", es.UnhealthySince.Format(time.RFC3339), es.LastError)
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBackend - a server counting its calls, which fails with 503 while
+// down is set.
+type testBackend struct {
+	*httptest.Server
+	calls int32
+	down  int32
+}
+
+func newTestBackend() *testBackend {
+	b := &testBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			atomic.AddInt32(&b.calls, 1)
+		}
+		if atomic.LoadInt32(&b.down) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"Foo":"` + r.Host + `"}`))
+	}))
+	return b
+}
+
+func TestMultiClientStrategies(t *testing.T) {
+	b1, b2, b3 := newTestBackend(), newTestBackend(), newTestBackend()
+	defer b1.Close()
+	defer b2.Close()
+	defer b3.Close()
+
+	mc, err := NewMultiClient([]string{b1.URL, b2.URL, b3.URL}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := mc.Get(context.Background(), "/x", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b1.calls != 2 || b2.calls != 2 || b3.calls != 2 {
+		t.Logf("expected even round robin, got %d %d %d", b1.calls, b2.calls, b3.calls)
+		t.Fail()
+	}
+
+	mc.Strategy = PriorityFailover
+	for i := 0; i < 3; i++ {
+		if err := mc.Get(context.Background(), "/x", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b1.calls != 5 {
+		t.Log("expected priority failover to stick to the first endpoint, got ", b1.calls)
+		t.Fail()
+	}
+
+	mc.Strategy = Random
+	for i := 0; i < 30; i++ {
+		if err := mc.Get(context.Background(), "/x", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b1.calls+b2.calls+b3.calls != 39 {
+		t.Log("unexpected total calls ", b1.calls+b2.calls+b3.calls)
+		t.Fail()
+	}
+
+	mc.Strategy = LeastOutstanding
+	mc.mu.Lock()
+	mc.states[b1.URL].Outstanding = 5
+	mc.states[b3.URL].Outstanding = 5
+	mc.mu.Unlock()
+	before := b2.calls
+	for i := 0; i < 3; i++ {
+		if err := mc.Get(context.Background(), "/x", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b2.calls != before+3 {
+		t.Log("expected least outstanding to pick the idle endpoint")
+		t.Fail()
+	}
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	b1, b2 := newTestBackend(), newTestBackend()
+	defer b1.Close()
+	defer b2.Close()
+
+	mc, err := NewMultiClient([]string{b1.URL, b2.URL}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc.Strategy = PriorityFailover
+	mc.HealthCheckPath = "/health"
+	mc.ProbeInterval = 10 * time.Millisecond
+
+	atomic.StoreInt32(&b1.down, 1)
+	out := &testResponse{}
+	if err := mc.Get(context.Background(), "/x", nil, out); err != nil {
+		t.Fatal("expected failover to the second endpoint, got ", err)
+	}
+	if out.Foo != b2.Listener.Addr().String() {
+		t.Log("expected response from second endpoint, got ", out.Foo)
+		t.Fail()
+	}
+	eps := mc.Endpoints()
+	if len(eps) != 2 || eps[0].Healthy || eps[0].Failures != 1 || eps[0].LastError == "" || !eps[1].Healthy {
+		t.Logf("unexpected endpoint state %v", eps)
+		t.Fail()
+	}
+
+	// POST without an idempotency key doesn't fail over.
+	mc.mu.Lock()
+	mc.states[b1.URL].Healthy = true
+	mc.mu.Unlock()
+	if err := mc.Post(context.Background(), "/x", nil, &testResponse{}, nil); StatusCode(err) != 503 {
+		t.Log("expected POST to fail without failover, got ", err)
+		t.Fail()
+	}
+
+	// Once the backend recovers, a probe brings it back.
+	atomic.StoreInt32(&b1.down, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for !mc.Endpoints()[0].Healthy && time.Now().Before(deadline) {
+		mc.Get(context.Background(), "/x", nil, nil)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !mc.Endpoints()[0].Healthy {
+		t.Log("expected probe to mark the endpoint healthy again")
+		t.Fail()
+	}
+}
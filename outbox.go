@@ -0,0 +1,612 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboxMaxAttempts = 10
+	defaultOutboxMinBackoff  = time.Second
+	defaultOutboxMaxBackoff  = 5 * time.Minute
+)
+
+// OutboxState - where a message is in an Outbox.
+type OutboxState int
+
+const (
+	// OutboxUnknown - the outbox never assigned the sequence number.
+	OutboxUnknown OutboxState = iota
+
+	// OutboxPending - waiting to be delivered.
+	OutboxPending
+
+	// OutboxDead - given up on, in the dead letters.
+	OutboxDead
+
+	// OutboxDone - delivered.
+	OutboxDone
+
+	// OutboxRemoved - removed from the dead letters without being
+	// delivered.
+	OutboxRemoved
+)
+
+func (s OutboxState) String() string {
+	switch s {
+	case OutboxUnknown:
+		return "unknown"
+	case OutboxPending:
+		return "pending"
+	case OutboxDead:
+		return "dead"
+	case OutboxDone:
+		return "done"
+	case OutboxRemoved:
+		return "removed"
+	}
+	return fmt.Sprintf("OutboxState(%d)", int(s))
+}
+
+// OutboxMessage - a request held by an Outbox, exactly as it will be sent.
+type OutboxMessage struct {
+	Seq     uint64      `json:"seq"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body,omitempty"`
+	Created time.Time   `json:"created"`
+
+	// Attempts, LastError, NextAttempt - the delivery history so far.
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// Outbox - a durable store-and-forward queue for mutating requests, so
+// writes made while an API is down are delivered once it is back, even
+// across restarts.  Messages are kept as files in a directory and
+// delivered one at a time, in the order they were enqueued, each carrying
+// an Idempotency-Key so that a delivery repeated after a crash is
+// harmless.  A message that fails with a retryable error holds up the ones
+// behind it until it is delivered or reaches MaxAttempts; one that fails
+// otherwise goes straight to the dead letters.
+//
+// Headers the Client's Redactor treats as secret are not written to disk,
+// so Enqueue refuses them, as it does per-request options, such as those
+// set by a RequestBuilder.  Set
+// credentials in Client.FixupCallback instead.  It runs when a message is
+// enqueued, and again on the stored request before each delivery, so it
+// must set headers rather than add to them.
+type Outbox struct {
+	// Client - sends the messages, with its retry policy, limiter and so
+	// on applying to each delivery attempt.
+	Client *Client
+
+	// MaxAttempts - the delivery attempts before a message becomes a dead
+	// letter.  Zero means 10.
+	MaxAttempts int
+
+	// MinBackoff, MaxBackoff - the delay after a failed delivery, doubling
+	// for each attempt, with jitter.  Zero means 1s and 5m.  A Retry-After
+	// header takes precedence, up to the maximum.
+	MinBackoff, MaxBackoff time.Duration
+
+	dir      string
+	mu       sync.Mutex
+	seq      uint64
+	pending  []*OutboxMessage
+	dead     []*OutboxMessage
+	removed  map[uint64]bool
+	wake     chan struct{}
+	delivery sync.Mutex
+}
+
+// OpenOutbox - opens the outbox kept in dir, creating it if need be, and
+// loads any messages left from before.  Call Run to deliver them.
+func OpenOutbox(dir string, cl *Client) (*Outbox, error) {
+	ob := &Outbox{Client: cl, dir: dir, wake: make(chan struct{}, 1)}
+	for _, sub := range []string{"pending", "dead"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if ob.pending, err = ob.load("pending"); err != nil {
+		return nil, err
+	}
+	if ob.dead, err = ob.load("dead"); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "seq"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if ob.seq, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, fmt.Errorf("outbox sequence file: %w", err)
+		}
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "removed"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ob.removed = make(map[uint64]bool)
+	for _, f := range strings.Fields(string(b)) {
+		seq, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("outbox removed file: %w", err)
+		}
+		ob.removed[seq] = true
+	}
+
+	// A crash part way through moving or removing a message can leave it
+	// in two places.  A removal is finished, and otherwise the dead letter
+	// is kept, so a message is never both delivered and dead.
+	ob.dead, err = ob.prune("dead", ob.dead, func(m *OutboxMessage) bool { return ob.removed[m.Seq] })
+	if err != nil {
+		return nil, err
+	}
+	ob.pending, err = ob.prune("pending", ob.pending, func(m *OutboxMessage) bool {
+		return ob.removed[m.Seq] || ob.deadIndex(m.Seq) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A crash between storing a message and its sequence number leaves
+	// the sequence file behind.
+	for _, msgs := range [][]*OutboxMessage{ob.pending, ob.dead} {
+		for _, m := range msgs {
+			if m.Seq > ob.seq {
+				ob.seq = m.Seq
+			}
+		}
+	}
+	return ob, nil
+}
+
+// prune - deletes the messages in a subdirectory for which drop is true,
+// returning the rest.
+func (ob *Outbox) prune(sub string, msgs []*OutboxMessage, drop func(*OutboxMessage) bool) ([]*OutboxMessage, error) {
+	kept := msgs[:0]
+	for _, m := range msgs {
+		if !drop(m) {
+			kept = append(kept, m)
+		} else if err := ob.remove(sub, m.Seq); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// load - reads the messages in a subdirectory, in sequence order.
+func (ob *Outbox) load(sub string) ([]*OutboxMessage, error) {
+	entries, err := os.ReadDir(filepath.Join(ob.dir, sub))
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*OutboxMessage
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(ob.dir, sub, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := &OutboxMessage{}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, fmt.Errorf("outbox message %s: %w", e.Name(), err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// Enqueue - builds the request with Client.NewRequest, just as
+// ReqWithHeaders would, and stores it for delivery, returning its
+// sequence number.  It returns once the message is safely on disk.  Only
+// POST, PUT, PATCH and DELETE requests are accepted, and headers may not
+// include any the Redactor treats as secret, nor may ctx carry per-request
+// options, since they wouldn't be delivered.  The message gets the idempotency key from ctx, or a new one.
+func (ob *Outbox) Enqueue(ctx context.Context, baseURL *url.URL, method, path string, queryStruct,
+	requestBody interface{}, headers http.Header) (uint64, error) {
+	switch strings.ToUpper(method) {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return 0, fmt.Errorf("outbox only accepts mutating requests, not %s", method)
+	}
+	redactor := ob.Client.redactor()
+	for k := range headers {
+		if redactor.IsSecretHeader(k) {
+			return 0, fmt.Errorf("outbox can't store the secret header %s, set it in Client.FixupCallback", k)
+		}
+	}
+	if opts := optionsFromContext(ctx); opts != nil && !opts.isZero() {
+		return 0, fmt.Errorf("outbox can't store per-request options such as credentials or expected statuses")
+	}
+	if IdempotencyKeyFromContext(ctx) == "" && headers.Get(IdempotencyKeyHeader) == "" {
+		ctx = WithIdempotencyKey(ctx, NewIdempotencyKey())
+	}
+	req, err := ob.Client.NewRequest(ctx, baseURL, method, path, queryStruct, requestBody, headers)
+	if err != nil {
+		return 0, err
+	}
+	m := &OutboxMessage{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Header:  make(http.Header),
+		Created: time.Now(),
+	}
+	for k, v := range req.Header {
+		if !redactor.IsSecretHeader(k) {
+			m.Header[k] = v
+		}
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return 0, err
+		}
+		m.Body, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	// The message is stored before the sequence number, so that a failure
+	// never leaves a number taken by a message that doesn't exist.
+	m.Seq = ob.seq + 1
+	if err := ob.write("pending", m); err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(filepath.Join(ob.dir, "seq"), []byte(strconv.FormatUint(m.Seq, 10))); err != nil {
+		ob.remove("pending", m.Seq)
+		return 0, err
+	}
+	ob.seq = m.Seq
+	ob.pending = append(ob.pending, m)
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+	return m.Seq, nil
+}
+
+// Run - delivers messages as they become due until ctx is done, then
+// returns ctx's error.  Only one Run or Flush delivers at a time.
+func (ob *Outbox) Run(ctx context.Context) error {
+	for {
+		wait, _ := ob.deliver(ctx, false)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-ob.wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Flush - tries to deliver every pending message straight away, ignoring
+// their backoff.  A message that fails for good moves to the dead letters
+// without stopping it, and can be found there or with Status.  A retryable
+// failure does stop it, and its error is returned, with the message still
+// pending.
+func (ob *Outbox) Flush(ctx context.Context) error {
+	_, err := ob.deliver(ctx, true)
+	return err
+}
+
+// deliver - sends pending messages in order until none are left, one
+// fails and stays pending, or, unless force is set, the next isn't due
+// yet.  It returns how
+// long until the next message is due, or -1 if none are pending.
+func (ob *Outbox) deliver(ctx context.Context, force bool) (time.Duration, error) {
+	ob.delivery.Lock()
+	defer ob.delivery.Unlock()
+	for ctx.Err() == nil {
+		ob.mu.Lock()
+		if len(ob.pending) == 0 {
+			ob.mu.Unlock()
+			return -1, nil
+		}
+		m := ob.pending[0]
+		if wait := time.Until(m.NextAttempt); wait > 0 && !force {
+			ob.mu.Unlock()
+			return wait, nil
+		}
+		ob.mu.Unlock()
+
+		err := ob.send(ctx, m)
+		if err != nil && ctx.Err() != nil {
+			// Cut short, so not the message's fault.
+			return 0, err
+		}
+
+		ob.mu.Lock()
+		if err == nil {
+			err = ob.remove("pending", m.Seq)
+			ob.removePending(m.Seq)
+			ob.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		m.Attempts++
+		m.LastError = err.Error()
+		dead, werr := ob.failed(m, err)
+		if werr != nil {
+			err = werr
+		} else if dead {
+			ob.mu.Unlock()
+			continue
+		}
+		wait := time.Duration(-1)
+		if len(ob.pending) > 0 {
+			wait = time.Until(ob.pending[0].NextAttempt)
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		ob.mu.Unlock()
+		return wait, err
+	}
+	return 0, ctx.Err()
+}
+
+// failed - records a failed delivery of m, either scheduling another
+// attempt or moving it to the dead letters, which it reports.  ob.mu is
+// held.
+func (ob *Outbox) failed(m *OutboxMessage, err error) (bool, error) {
+	maxAttempts := ob.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultOutboxMaxAttempts
+	}
+	if IsRetryable(err) && m.Attempts < maxAttempts {
+		backoff := &RetryPolicy{MinBackoff: ob.MinBackoff, MaxBackoff: ob.MaxBackoff}
+		if backoff.MinBackoff <= 0 {
+			backoff.MinBackoff = defaultOutboxMinBackoff
+		}
+		if backoff.MaxBackoff <= 0 {
+			backoff.MaxBackoff = defaultOutboxMaxBackoff
+		}
+		m.NextAttempt = time.Now().Add(backoff.backoff(m.Attempts, err))
+		return false, ob.write("pending", m)
+	}
+	if werr := ob.write("dead", m); werr != nil {
+		return false, werr
+	}
+	ob.removePending(m.Seq)
+	ob.dead = append(ob.dead, m)
+	return true, ob.remove("pending", m.Seq)
+}
+
+// send - makes one delivery attempt at m.
+func (ob *Outbox) send(ctx context.Context, m *OutboxMessage) error {
+	var body io.Reader
+	if len(m.Body) > 0 {
+		body = bytes.NewReader(m.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, m.Method, m.URL, body)
+	if err != nil {
+		return ob.Client.requestError(m.Method, m.URL, "", 1, PhaseEncode, err)
+	}
+	req.Header = m.Header.Clone()
+	if ob.Client.FixupCallback != nil {
+		if err := ob.Client.FixupCallback(req); err != nil {
+			return ob.Client.requestError(m.Method, m.URL, "", 1, PhaseEncode, err)
+		}
+	}
+	_, err = ob.Client.Do(req, nil)
+	return err
+}
+
+// Status - reports where the message with sequence number seq is.
+func (ob *Outbox) Status(seq uint64) OutboxState {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	for _, m := range ob.pending {
+		if m.Seq == seq {
+			return OutboxPending
+		}
+	}
+	for _, m := range ob.dead {
+		if m.Seq == seq {
+			return OutboxDead
+		}
+	}
+	if ob.removed[seq] {
+		return OutboxRemoved
+	}
+	if seq > 0 && seq <= ob.seq {
+		return OutboxDone
+	}
+	return OutboxUnknown
+}
+
+// Pending - returns copies of the messages waiting to be delivered, in
+// delivery order.
+func (ob *Outbox) Pending() []OutboxMessage {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return copyMessages(ob.pending)
+}
+
+// DeadLetters - returns copies of the messages given up on.
+func (ob *Outbox) DeadLetters() []OutboxMessage {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return copyMessages(ob.dead)
+}
+
+// Requeue - moves the dead letter seq back into the queue, with its
+// attempts reset.  It keeps its sequence number and idempotency key, so
+// takes its original place in the delivery order.
+func (ob *Outbox) Requeue(seq uint64) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	i := ob.deadIndex(seq)
+	if i < 0 {
+		return fmt.Errorf("outbox has no dead letter %d", seq)
+	}
+	m := ob.dead[i]
+	m.Attempts, m.LastError, m.NextAttempt = 0, "", time.Time{}
+	if err := ob.write("pending", m); err != nil {
+		return err
+	}
+	ob.dead = append(ob.dead[:i], ob.dead[i+1:]...)
+	j := len(ob.pending)
+	for j > 0 && ob.pending[j-1].Seq > seq {
+		j--
+	}
+	ob.pending = append(ob.pending, nil)
+	copy(ob.pending[j+1:], ob.pending[j:])
+	ob.pending[j] = m
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+	return ob.remove("dead", seq)
+}
+
+// Remove - deletes the dead letter seq for good.  Its Status is
+// OutboxRemoved from then on.
+func (ob *Outbox) Remove(seq uint64) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	i := ob.deadIndex(seq)
+	if i < 0 {
+		return fmt.Errorf("outbox has no dead letter %d", seq)
+	}
+	// Record the removal first, so a crash can't lose track of it.
+	seqs := make([]string, 0, len(ob.removed)+1)
+	for r := range ob.removed {
+		seqs = append(seqs, strconv.FormatUint(r, 10))
+	}
+	seqs = append(seqs, strconv.FormatUint(seq, 10))
+	if err := writeFileAtomic(filepath.Join(ob.dir, "removed"), []byte(strings.Join(seqs, "\n"))); err != nil {
+		return err
+	}
+	ob.removed[seq] = true
+	ob.dead = append(ob.dead[:i], ob.dead[i+1:]...)
+	return ob.remove("dead", seq)
+}
+
+func (ob *Outbox) removePending(seq uint64) {
+	for i, m := range ob.pending {
+		if m.Seq == seq {
+			ob.pending = append(ob.pending[:i], ob.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (ob *Outbox) deadIndex(seq uint64) int {
+	for i, m := range ob.dead {
+		if m.Seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// path - the file holding message seq in a subdirectory.  The sequence
+// number is zero padded so directory order is sequence order.
+func (ob *Outbox) path(sub string, seq uint64) string {
+	return filepath.Join(ob.dir, sub, fmt.Sprintf("%020d.json", seq))
+}
+
+// remove - deletes the file holding message seq in a subdirectory, and
+// syncs the directory so that it stays deleted.
+func (ob *Outbox) remove(sub string, seq uint64) error {
+	if err := os.Remove(ob.path(sub, seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(filepath.Join(ob.dir, sub))
+}
+
+func (ob *Outbox) write(sub string, m *OutboxMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ob.path(sub, m.Seq), b)
+}
+
+// writeFileAtomic - writes b to path via a synced temporary file, then
+// syncs the directory, so a crash leaves either the old contents or the
+// new, and the new ones stay once it returns.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir - flushes the directory entries of dir to disk.  Windows can't
+// sync a directory, and doesn't need to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func copyMessages(msgs []*OutboxMessage) []OutboxMessage {
+	out := make([]OutboxMessage, len(msgs))
+	for i, m := range msgs {
+		out[i] = *m
+	}
+	return out
+}
@@ -0,0 +1,270 @@
+package restclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outboxServer - records deliveries, failing with status while it is set.
+type outboxServer struct {
+	mu       sync.Mutex
+	status   int
+	attempts map[string]int
+	bodies   []string
+}
+
+func (s *outboxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[r.Header.Get(IdempotencyKeyHeader)]++
+	if r.Header.Get("Authorization") != "Bearer fresh" {
+		w.WriteHeader(401)
+		return
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	s.bodies = append(s.bodies, r.Method+" "+r.URL.Path+" "+string(body))
+}
+
+func (s *outboxServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func newOutboxClient(t *testing.T) *Client {
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.FixupCallback = func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer fresh")
+		return nil
+	}
+	return cl
+}
+
+func TestOutbox(t *testing.T) {
+	srv := &outboxServer{status: 503, attempts: make(map[string]int)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	su, _ := url.Parse(ts.URL)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	ob, err := OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob.MinBackoff = time.Millisecond
+	if _, err := ob.Enqueue(ctx, su, "GET", "/", nil, nil, nil); err == nil {
+		t.Log("expected GET to be refused")
+		t.Fail()
+	}
+	for _, b := range []string{"a", "b", "c"} {
+		if _, err := ob.Enqueue(ctx, su, "POST", "/things", nil, &testResponse{Foo: b}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ob.Flush(ctx); err == nil || !IsRetryable(err) {
+		t.Fatal("expected a retryable failure, got ", err)
+	}
+	pending := ob.Pending()
+	if len(pending) != 3 || pending[0].Attempts != 1 || pending[0].NextAttempt.IsZero() {
+		t.Fatalf("unexpected pending messages %#v", pending)
+	}
+	b, _ := os.ReadFile(ob.path("pending", 1))
+	if strings.Contains(string(b), "fresh") || !strings.Contains(string(b), IdempotencyKeyHeader) {
+		t.Log("expected the credentials to be kept off disk and the key on it ", string(b))
+		t.Fail()
+	}
+
+	// Restart, then let the server recover.
+	ob, err = OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob.MinBackoff = time.Millisecond
+	if len(ob.Pending()) != 3 || ob.Pending()[0].Attempts != 1 {
+		t.Fatalf("expected the messages to survive a restart, got %#v", ob.Pending())
+	}
+	srv.setStatus(0)
+	runCtx, cancel := context.WithCancel(ctx)
+	ran := make(chan error)
+	go func() { ran <- ob.Run(runCtx) }()
+	waitFor(t, func() bool { return len(ob.Pending()) == 0 })
+	seq, err := ob.Enqueue(ctx, su, "PUT", "/things/d", nil, &testResponse{Foo: "d"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return ob.Status(seq) == OutboxDone })
+	cancel()
+	<-ran
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	want := []string{
+		`POST /things {"Foo":"a","Bar":"","Baz":0}`,
+		`POST /things {"Foo":"b","Bar":"","Baz":0}`,
+		`POST /things {"Foo":"c","Bar":"","Baz":0}`,
+		`PUT /things/d {"Foo":"d","Bar":"","Baz":0}`,
+	}
+	if strings.Join(srv.bodies, "\n") != strings.Join(want, "\n") {
+		t.Log("unexpected deliveries ", srv.bodies)
+		t.Fail()
+	}
+	if srv.attempts[pending[0].Header.Get(IdempotencyKeyHeader)] != 2 {
+		t.Log("expected the retry to reuse the idempotency key ", srv.attempts)
+		t.Fail()
+	}
+	if ob.Status(seq+1) != OutboxUnknown {
+		t.Log("expected an unassigned sequence number to be unknown")
+		t.Fail()
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	srv := &outboxServer{status: 422, attempts: make(map[string]int)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	su, _ := url.Parse(ts.URL)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	ob, err := OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ob.Enqueue(ctx, su, "DELETE", "/things/1", nil, nil, nil)
+	second, _ := ob.Enqueue(ctx, su, "DELETE", "/things/2", nil, nil, nil)
+	if err := ob.Flush(ctx); err != nil {
+		t.Log("expected dead letters not to fail the flush, got ", err)
+		t.Fail()
+	}
+	dead := ob.DeadLetters()
+	if len(dead) != 2 || dead[0].Seq != first || !strings.Contains(dead[0].LastError, "422") {
+		t.Fatalf("expected both messages to be dead letters, got %#v", dead)
+	}
+	if ob.Status(first) != OutboxDead {
+		t.Log("unexpected status ", ob.Status(first))
+		t.Fail()
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "dead")); len(entries) != 2 {
+		t.Log("expected the dead letters on disk, got ", len(entries))
+		t.Fail()
+	}
+
+	srv.setStatus(0)
+	if err := ob.Requeue(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := ob.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ob.Remove(second); err != nil {
+		t.Fatal(err)
+	}
+	if ob.Status(first) != OutboxDone || ob.Status(second) != OutboxRemoved || len(ob.DeadLetters()) != 0 {
+		t.Log("expected the dead letters to be handled")
+		t.Fail()
+	}
+	if ob.Remove(second) == nil || ob.Requeue(second) == nil {
+		t.Log("expected an error for a missing dead letter")
+		t.Fail()
+	}
+
+	// The removal survives a restart.
+	ob, err = OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ob.Status(first) != OutboxDone || ob.Status(second) != OutboxRemoved {
+		t.Log("unexpected status after reopening ", ob.Status(first), ob.Status(second))
+		t.Fail()
+	}
+}
+
+func TestOutboxSecretHeaders(t *testing.T) {
+	su, _ := url.Parse("http://example.com")
+	ob, err := OpenOutbox(t.TempDir(), newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ob.Enqueue(context.Background(), su, "POST", "/things", nil, nil,
+		http.Header{"Authorization": {"Bearer secret"}})
+	if err == nil || !strings.Contains(err.Error(), "Authorization") {
+		t.Log("expected the secret header to be refused, got ", err)
+		t.Fail()
+	}
+	for _, opts := range []*requestOptions{
+		{auth: func(*http.Request) error { return nil }},
+		{expect: []int{http.StatusNotFound}},
+	} {
+		ctx := withRequestOptions(context.Background(), opts)
+		if _, err = ob.Enqueue(ctx, su, "POST", "/things", nil, nil, nil); err == nil {
+			t.Logf("expected per-request options %#v to be refused", opts)
+			t.Fail()
+		}
+	}
+	if len(ob.Pending()) != 0 {
+		t.Log("expected nothing to be stored")
+		t.Fail()
+	}
+}
+
+func TestOutboxCrashRecovery(t *testing.T) {
+	su, _ := url.Parse("http://example.com")
+	dir := t.TempDir()
+	ctx := context.Background()
+	ob, err := OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ob.Enqueue(ctx, su, "DELETE", "/things/1", nil, nil, nil)
+	second, _ := ob.Enqueue(ctx, su, "DELETE", "/things/2", nil, nil, nil)
+	third, _ := ob.Enqueue(ctx, su, "DELETE", "/things/3", nil, nil, nil)
+
+	// Crash while moving the first to the dead letters, and after storing
+	// the third but before its sequence number.
+	b, err := os.ReadFile(ob.path("pending", first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(ob.path("dead", first), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "seq"), []byte(strconv.FormatUint(second, 10)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ob, err = OpenOutbox(dir, newOutboxClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := ob.Pending()
+	if len(pending) != 2 || pending[0].Seq != second || ob.Status(first) != OutboxDead {
+		t.Logf("expected the dead letter out of the queue, got %#v", pending)
+		t.Fail()
+	}
+	if _, err = os.Stat(ob.path("pending", first)); !os.IsNotExist(err) {
+		t.Log("expected the leftover pending file to be removed, got ", err)
+		t.Fail()
+	}
+	if seq, _ := ob.Enqueue(ctx, su, "DELETE", "/things/4", nil, nil, nil); seq != third+1 {
+		t.Log("expected the sequence to carry on after the stored messages, got ", seq)
+		t.Fail()
+	}
+}
@@ -0,0 +1,152 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var pathPlaceholder = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+type pathParamsKey struct{}
+
+type routeKey struct{}
+
+// WithPathParams - returns a copy of ctx carrying the parameters used to
+// fill in a path template.  When a request is made with this context, any
+// {name} placeholders in the path argument are replaced with the matching,
+// path escaped, parameter:
+//
+//	ctx = restclient.WithPathParams(ctx, &KeyPath{ID: id, KID: kid})
+//	err := bc.Get(ctx, "/users/{id}/keys/{kid}", nil, &resp)
+//
+// params may be a struct or pointer to struct, whose fields are named with
+// the `path:"name"` tag (untagged fields use the field name and `path:"-"`
+// skips a field), or a map with string keys.  Structs are validated with
+// the same validator as query structs and request bodies.  The unfilled
+// template is kept as the route name, see RouteFromContext.
+func WithPathParams(ctx context.Context, params interface{}) context.Context {
+	return context.WithValue(ctx, pathParamsKey{}, params)
+}
+
+// RouteFromContext - returns the path template a request was made with,
+// suitable as a low cardinality name for logging and metrics.  This is
+// available on the request context, for instance from FixupCallback, and is
+// empty when no template was expanded.
+func RouteFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+// ExpandPath - fills in the {name} placeholders of tmpl from params, which
+// is a struct or map as described for WithPathParams.  Each value is path
+// escaped, so it always ends up as a single path segment.  The values "."
+// and "..", which would be dot segments however they are escaped, are
+// rejected.
+func (cl *Client) ExpandPath(tmpl string, params interface{}) (string, error) {
+	if params == nil {
+		return tmpl, nil
+	}
+	values, isStruct, err := pathValues(params)
+	if err != nil {
+		return "", err
+	}
+	if isStruct && !cl.SkipValidate {
+		if err := cl.validate(params); err != nil {
+			return "", err
+		}
+	}
+
+	var invalid []string
+	expanded := pathPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := m[1 : len(m)-1]
+		v := values[name]
+		if v == "" {
+			invalid = append(invalid, fmt.Sprintf("Required path parameter %s is missing or empty", name))
+			return m
+		}
+		if v == "." || v == ".." {
+			invalid = append(invalid, fmt.Sprintf("Path parameter %s may not be %q", name, v))
+			return m
+		}
+		return url.PathEscape(v)
+	})
+	if len(invalid) > 0 {
+		return "", ValidationErrors{
+			parsedErrStr: fmt.Sprintf("Validation error: %s", strings.Join(invalid, " ; ")),
+		}
+	}
+	return expanded, nil
+}
+
+// expandPathFromContext - expands path with the parameters attached to ctx,
+// if any, returning the expanded path and the route name.
+func (cl *Client) expandPathFromContext(ctx context.Context, path string) (string, string, error) {
+	params := ctx.Value(pathParamsKey{})
+	if params == nil || !pathPlaceholder.MatchString(path) {
+		return path, "", nil
+	}
+	expanded, err := cl.ExpandPath(path, params)
+	if err != nil {
+		return path, path, err
+	}
+	return expanded, path, nil
+}
+
+// pathValues - flattens params into a map of placeholder name to value.
+// The second return value reports whether params was a struct.
+func pathValues(params interface{}) (map[string]string, bool, error) {
+	rv := reflect.ValueOf(params)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	values := make(map[string]string)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false, fmt.Errorf("path parameters map must have string keys, got %s", rv.Type())
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			values[iter.Key().String()] = pathValueString(iter.Value())
+		}
+		return values, false, nil
+
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag, ok := f.Tag.Lookup("path"); ok {
+				if tag == "-" {
+					continue
+				}
+				if tag, _, _ = strings.Cut(tag, ","); tag != "" {
+					name = tag
+				}
+			}
+			values[name] = pathValueString(rv.Field(i))
+		}
+		return values, true, nil
+	}
+	return nil, false, fmt.Errorf("path parameters must be a struct or map, got %s", rv.Kind())
+}
+
+func pathValueString(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
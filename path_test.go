@@ -0,0 +1,96 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testKeyPath struct {
+	UID string `path:"id" validate:"required"`
+	KID int    `path:"kid" validate:"gte=1"`
+}
+
+func TestExpandPath(t *testing.T) {
+	cl := &Client{}
+	got, err := cl.ExpandPath("/users/{id}/keys/{kid}", &testKeyPath{UID: "a b/c", KID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/users/a%20b%2Fc/keys/5" {
+		t.Log("unexpected expanded path ", got)
+		t.Fail()
+	}
+
+	got, err = cl.ExpandPath("/users/{id}", map[string]string{"id": "59"})
+	if err != nil || got != "/users/59" {
+		t.Logf("unexpected map expansion %q, %v", got, err)
+		t.Fail()
+	}
+
+	_, err = cl.ExpandPath("/users/{id}/keys/{kid}", &testKeyPath{UID: "59"})
+	var ve ValidationErrors
+	if !errors.As(err, &ve) {
+		t.Log("expected validation error for struct, got ", err)
+		t.Fail()
+	}
+
+	_, err = cl.ExpandPath("/users/{id}/keys/{kid}", map[string]string{"id": "59"})
+	if !errors.As(err, &ve) || !strings.Contains(ve.Error(), "Required path parameter kid") {
+		t.Log("expected missing parameter error, got ", err)
+		t.Fail()
+	}
+
+	for _, dots := range []string{".", ".."} {
+		_, err = cl.ExpandPath("/users/{id}/keys", map[string]string{"id": dots})
+		if !errors.As(err, &ve) || !strings.Contains(ve.Error(), "Path parameter id") {
+			t.Logf("expected %q to be rejected, got %v", dots, err)
+			t.Fail()
+		}
+	}
+	if got, err = cl.ExpandPath("/users/{id}", map[string]string{"id": "..."}); err != nil || got != "/users/..." {
+		t.Logf("unexpected expansion of three dots %q, %v", got, err)
+		t.Fail()
+	}
+}
+
+func TestPathParamsRequest(t *testing.T) {
+	var gotPath, gotRoute string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		w.WriteHeader(404)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(&ClientConfig{
+		FixupCallback: func(req *http.Request) error {
+			gotRoute = RouteFromContext(req.Context())
+			return nil
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	ctx := WithPathParams(context.Background(), &testKeyPath{UID: "a/b", KID: 7})
+	err = bc.Get(ctx, "/users/{id}/keys/{kid}", nil, nil)
+	if gotPath != "/users/a%2Fb/keys/7" {
+		t.Log("unexpected request path ", gotPath)
+		t.Fail()
+	}
+	if gotRoute != "/users/{id}/keys/{kid}" {
+		t.Log("unexpected route ", gotRoute)
+		t.Fail()
+	}
+	var rqe *RequestError
+	if !errors.As(err, &rqe) || rqe.Route != "/users/{id}/keys/{kid}" {
+		t.Log("expected route on RequestError, got ", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,210 @@
+package restclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/spkg/bom"
+)
+
+// MediaTypeProblemJSON - the media type of RFC 9457 problem details bodies.
+const MediaTypeProblemJSON = "application/problem+json"
+
+// ProblemDetails - an RFC 9457 problem details object.  When a >= 400
+// response is served as application/problem+json, the body is decoded into
+// one of these and can be extracted from the returned error with errors.As:
+//
+//	var pd *restclient.ProblemDetails
+//	if errors.As(err, &pd) {
+//		fmt.Println(pd.Title, pd.Detail)
+//	}
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions - any members other than the standard ones above.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// Error - implement the Error interface.
+func (pd *ProblemDetails) Error() string {
+	msg := pd.Title
+	if msg == "" {
+		msg = pd.Type
+	}
+	if pd.Detail != "" {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += pd.Detail
+	}
+	return "problem: " + msg
+}
+
+// UnmarshalJSON - implements json.Unmarshaler, collecting extension
+// members into Extensions.
+func (pd *ProblemDetails) UnmarshalJSON(data []byte) error {
+	type plain ProblemDetails
+	if err := json.Unmarshal(data, (*plain)(pd)); err != nil {
+		return err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, k)
+	}
+	if len(members) > 0 {
+		pd.Extensions = members
+	} else {
+		pd.Extensions = nil
+	}
+	return nil
+}
+
+// MarshalJSON - implements json.Marshaler, flattening Extensions into the
+// top level object.
+func (pd *ProblemDetails) MarshalJSON() ([]byte, error) {
+	type plain ProblemDetails
+	if len(pd.Extensions) == 0 {
+		return json.Marshal((*plain)(pd))
+	}
+	std, err := json.Marshal((*plain)(pd))
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]interface{}, len(pd.Extensions)+5)
+	for k, v := range pd.Extensions {
+		members[k] = v
+	}
+	if err := json.Unmarshal(std, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// ProblemDetails - returns the decoded problem details, if the response
+// was served as application/problem+json.
+func (rs *ResponseError) ProblemDetails() (*ProblemDetails, bool) {
+	pd, ok := rs.ErrorBody.(*ProblemDetails)
+	return pd, ok
+}
+
+// RegisterErrorBody - registers the type of errType as the error body type
+// for responses >= 400 served with the given media type.  errType must be a
+// pointer, for instance &MyAPIError{}; a fresh value of the same type is
+// decoded for each response and stored in ResponseError.ErrorBody, so it
+// can be extracted with errors.As.  application/problem+json maps to
+// ProblemDetails unless overridden here.  This is not safe to call
+// concurrently with requests.
+func (cl *Client) RegisterErrorBody(mediaType string, errType error) {
+	t := reflect.TypeOf(errType)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic("restclient: RegisterErrorBody requires a pointer error type")
+	}
+	if cl.errorBodies == nil {
+		cl.errorBodies = make(map[string]reflect.Type)
+	}
+	cl.errorBodies[strings.ToLower(mediaType)] = t.Elem()
+}
+
+// statusError - an error body type registered for a range of status codes.
+type statusError struct {
+	from, to int
+	t        reflect.Type
+}
+
+// RegisterStatusError - registers the type of errType as the error body
+// type for responses with a status code between from and to, inclusive.
+// For instance:
+//
+//	cl.RegisterStatusError(404, 404, &NotFoundError{})
+//	cl.RegisterStatusError(400, 499, &APIError{})
+//
+// The body is decoded the same way as a successful response body, and the
+// result is stored in ResponseError.ErrorBody so that errors.As finds both
+// it and the ResponseError.  When ranges overlap the narrowest one wins,
+// and status code registrations take precedence over RegisterErrorBody
+// media types.  This is not safe to call concurrently with requests.
+func (cl *Client) RegisterStatusError(from, to int, errType error) {
+	t := reflect.TypeOf(errType)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic("restclient: RegisterStatusError requires a pointer error type")
+	}
+	if from > to {
+		from, to = to, from
+	}
+	cl.statusErrors = append(cl.statusErrors, statusError{from: from, to: to, t: t.Elem()})
+}
+
+// errorBodyType - looks up the error body type registered for the status
+// code or media type of resp.
+func (cl *Client) errorBodyType(resp *http.Response) reflect.Type {
+	var best *statusError
+	for i := range cl.statusErrors {
+		se := &cl.statusErrors[i]
+		if resp.StatusCode < se.from || resp.StatusCode > se.to {
+			continue
+		}
+		if best == nil || se.to-se.from < best.to-best.from {
+			best = se
+		}
+	}
+	if best != nil {
+		return best.t
+	}
+
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	if t, ok := cl.errorBodies[mt]; ok {
+		return t
+	}
+	if mt == MediaTypeProblemJSON {
+		return reflect.TypeOf(ProblemDetails{})
+	}
+	return nil
+}
+
+// decodeErrorBody - decodes body into a new value of the registered error
+// body type, returning nil if there is none or decoding fails.
+func (cl *Client) decodeErrorBody(resp *http.Response, body []byte) error {
+	t := cl.errorBodyType(resp)
+	if t == nil || len(body) == 0 {
+		return nil
+	}
+	v := reflect.New(t).Interface()
+	var err error
+	if _, custom := v.(CustomDecoder); !custom && isProblemJSON(resp) {
+		// Problem details are always json, whatever codec the client uses.
+		var r io.Reader = bytes.NewReader(body)
+		if cl.StripBOM {
+			r = bom.NewReader(r)
+		}
+		err = json.NewDecoder(r).Decode(v)
+	} else {
+		err = cl.decodeBody(resp, bytes.NewReader(body), v)
+	}
+	if err != nil {
+		return nil
+	}
+	eb, _ := v.(error)
+	return eb
+}
+
+// isProblemJSON - reports whether resp is served as
+// application/problem+json.
+func isProblemJSON(resp *http.Response) bool {
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mt == MediaTypeProblemJSON
+}
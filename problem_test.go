@@ -0,0 +1,181 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type testAPIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *testAPIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func errorBodyServer(t *testing.T) (*httptest.Server, *url.URL) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+			w.WriteHeader(403)
+			w.Write([]byte(`{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.",` +
+				`"status":403,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc",` +
+				`"balance":30}`))
+		case "/vendor":
+			w.Header().Set("Content-Type", "application/vnd.test.error+json")
+			w.WriteHeader(409)
+			w.Write([]byte(`{"code":"conflict","message":"already exists"}`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			w.Write([]byte("boom"))
+		}
+	}))
+	su, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, su
+}
+
+func TestProblemDetails(t *testing.T) {
+	srv, su := errorBodyServer(t)
+	defer srv.Close()
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cl.Get(context.Background(), su, "/problem", nil, nil)
+	var pd *ProblemDetails
+	if !errors.As(err, &pd) {
+		t.Fatal("expected ProblemDetails in error chain, got ", err)
+	}
+	if pd.Status != 403 || pd.Title != "You do not have enough credit." || pd.Instance != "/account/12345/msgs/abc" {
+		t.Logf("unexpected problem details: %#v", pd)
+		t.Fail()
+	}
+	if pd.Extensions["balance"] != float64(30) {
+		t.Logf("expected balance extension, got %#v", pd.Extensions)
+		t.Fail()
+	}
+	var re *ResponseError
+	if !errors.As(err, &re) || re.StatusCode != 403 {
+		t.Log("expected ResponseError to remain in error chain, got ", err)
+		t.Fail()
+	}
+
+	err = cl.Get(context.Background(), su, "/other", nil, nil)
+	if errors.As(err, &pd) {
+		t.Log("did not expect ProblemDetails for text/plain error")
+		t.Fail()
+	}
+}
+
+func TestProblemDetailsWithCodec(t *testing.T) {
+	srv, su := errorBodyServer(t)
+	defer srv.Close()
+	for _, codec := range []Codec{FormCodec{}, ProtobufCodec{}} {
+		cl, err := NewClient(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl.Codec = codec
+		err = cl.Get(context.Background(), su, "/problem", nil, nil)
+		var pd *ProblemDetails
+		if !errors.As(err, &pd) || pd.Status != 403 {
+			t.Logf("%T: expected problem details decoded as json, got %v", codec, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestRegisterErrorBody(t *testing.T) {
+	srv, su := errorBodyServer(t)
+	defer srv.Close()
+	bc := &BaseClient{Client: &Client{Client: &http.Client{}}, BaseURL: su}
+	bc.RegisterErrorBody("application/vnd.test.error+json", &testAPIError{})
+
+	err := bc.Get(context.Background(), "/vendor", nil, nil)
+	var ae *testAPIError
+	if !errors.As(err, &ae) {
+		t.Fatal("expected registered error type in error chain, got ", err)
+	}
+	if ae.Code != "conflict" {
+		t.Log("unexpected error body: ", ae)
+		t.Fail()
+	}
+}
+
+func TestProblemDetailsMarshal(t *testing.T) {
+	pd := &ProblemDetails{Title: "t", Status: 400, Extensions: map[string]interface{}{"x": "y"}}
+	b, err := pd.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd2 := &ProblemDetails{}
+	if err := pd2.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if pd2.Title != "t" || pd2.Status != 400 || pd2.Extensions["x"] != "y" {
+		t.Logf("data not preserved on round trip: %s", b)
+		t.Fail()
+	}
+}
+
+type testNotFoundError struct {
+	Message string `json:"message"`
+}
+
+func (e *testNotFoundError) Error() string {
+	return "not found: " + e.Message
+}
+
+func TestRegisterStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message":"no such user"}`))
+		default:
+			w.WriteHeader(422)
+			w.Write([]byte(`{"code":"invalid","message":"bad input"}`))
+		}
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	bc := &BaseClient{Client: &Client{Client: &http.Client{}}, BaseURL: su}
+	bc.RegisterStatusError(400, 499, &testAPIError{})
+	bc.RegisterStatusError(404, 404, &testNotFoundError{})
+
+	err := bc.Get(context.Background(), "/missing", nil, nil)
+	var nf *testNotFoundError
+	if !errors.As(err, &nf) || nf.Message != "no such user" {
+		t.Log("expected NotFoundError for 404, got ", err)
+		t.Fail()
+	}
+	var re *ResponseError
+	if !errors.As(err, &re) || re.StatusCode != 404 {
+		t.Log("expected ResponseError to remain in error chain, got ", err)
+		t.Fail()
+	}
+
+	err = bc.Get(context.Background(), "/invalid", nil, nil)
+	var ae *testAPIError
+	if !errors.As(err, &ae) || ae.Code != "invalid" {
+		t.Log("expected APIError for 422, got ", err)
+		t.Fail()
+	}
+	if errors.As(err, &nf) {
+		t.Log("did not expect NotFoundError for 422")
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package restclient
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	mediaTypeJSON     = "application/json"
+	mediaTypeForm     = "application/x-www-form-urlencoded"
+	mediaTypeProtobuf = "application/x-protobuf"
+)
+
+// protoMediaType - the media type used for proto.Message bodies, depending
+// on whether the client is configured for the protojson mapping.
+func (cl *Client) protoMediaType() string {
+	if cl.ProtoJSON {
+		return mediaTypeJSON
+	}
+	return mediaTypeProtobuf
+}
+
+// marshalProto - encodes m either with the protobuf wire format or with
+// protojson, and returns the encoded bytes along with the content type.
+func (cl *Client) marshalProto(m proto.Message) ([]byte, string, error) {
+	if cl.ProtoJSON {
+		b, err := protojson.Marshal(m)
+		return b, mediaTypeJSON, err
+	}
+	b, err := proto.Marshal(m)
+	return b, mediaTypeProtobuf, err
+}
+
+// unmarshalProto - decodes the response into m.  The response Content-Type
+// decides between the wire format and protojson, falling back to the
+// client's configured mapping when the server doesn't say.
+func (cl *Client) unmarshalProto(resp *http.Response, r io.Reader, m proto.Message) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	mt := cl.protoMediaType()
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		if parsed, _, err := mime.ParseMediaType(ct); err == nil {
+			mt = parsed
+		}
+	}
+	switch mt {
+	case mediaTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+		return proto.Unmarshal(data, m)
+	case mediaTypeJSON:
+		return protojson.Unmarshal(data, m)
+	}
+	if cl.ProtoJSON {
+		return protojson.Unmarshal(data, m)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,99 @@
+package restclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// protoEchoServer - echoes the request body back, using the request
+// Content-Type for the response.
+func protoEchoServer(t *testing.T, gotHeader *http.Header) (*httptest.Server, *url.URL) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*gotHeader = r.Header.Clone()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	su, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal("Failed on url.Parse: ", err)
+	}
+	return srv, su
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	var hdr http.Header
+	srv, su := protoEchoServer(t, &hdr)
+	defer srv.Close()
+
+	req, err := structpb.NewStruct(map[string]interface{}{"name": "catpics", "ttl": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, protoJSON := range []bool{false, true} {
+		cl, err := NewClient(&ClientConfig{ProtoJSON: protoJSON}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &structpb.Struct{}
+		err = cl.Post(context.Background(), su, "/proto", nil, req, resp)
+		if err != nil {
+			t.Fatalf("ProtoJSON=%t: Failed client.Post: %s", protoJSON, err)
+		}
+		if !proto.Equal(req, resp) {
+			t.Logf("ProtoJSON=%t: data not preserved on round trip", protoJSON)
+			t.Fail()
+		}
+		wantCT := mediaTypeProtobuf
+		if protoJSON {
+			wantCT = mediaTypeJSON
+		}
+		if hdr.Get("Content-Type") != wantCT {
+			t.Logf("ProtoJSON=%t: expected Content-Type %s, got %s", protoJSON, wantCT, hdr.Get("Content-Type"))
+			t.Fail()
+		}
+		if hdr.Get("Accept") != wantCT {
+			t.Logf("ProtoJSON=%t: expected Accept %s, got %s", protoJSON, wantCT, hdr.Get("Accept"))
+			t.Fail()
+		}
+	}
+}
+
+func TestProtoResponseContentType(t *testing.T) {
+	want, _ := structpb.NewStruct(map[string]interface{}{"foo": "bar"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer in JSON even though the wire format was asked for.
+		b, _ := protojson.Marshal(want)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(b)
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &structpb.Struct{}
+	err = cl.Get(context.Background(), su, "/proto", nil, got)
+	if err != nil {
+		t.Fatal("Failed client.Get: ", err)
+	}
+	if !proto.Equal(want, got) {
+		t.Log("expected protojson response to be decoded, got ", got)
+		t.Fail()
+	}
+}
@@ -0,0 +1,228 @@
+package restclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redactor - describes which parts of a request carry secrets that must not
+// end up in error messages, logs or recordings.  All names are matched
+// case-insensitively.
+type Redactor struct {
+	// Headers - names of headers whose values are replaced.
+	Headers []string
+
+	// QueryParams - names of query parameters whose values are replaced.
+	QueryParams []string
+
+	// BodyFields - names of json object members and form fields whose
+	// values are replaced in request and response bodies.
+	BodyFields []string
+
+	// Replacement - the text substituted for redacted values.
+	Replacement string
+}
+
+// DefaultRedactor - the Redactor used when Client.Redactor is nil.
+var DefaultRedactor = &Redactor{
+	Headers: []string{
+		"Authorization",
+		"Proxy-Authorization",
+		"Cookie",
+		"Set-Cookie",
+		"X-Api-Key",
+		"X-Auth-Token",
+	},
+	QueryParams: []string{
+		"access_token",
+		"api_key",
+		"apikey",
+		"client_secret",
+		"key",
+		"password",
+		"secret",
+		"sig",
+		"signature",
+		"token",
+	},
+	BodyFields: []string{
+		"access_token",
+		"api_key",
+		"client_secret",
+		"password",
+		"refresh_token",
+		"secret",
+		"token",
+	},
+	Replacement: "REDACTED",
+}
+
+func (r *Redactor) replacement() string {
+	if r.Replacement == "" {
+		return "REDACTED"
+	}
+	return r.Replacement
+}
+
+// IsSecretHeader - reports whether values of the named header are redacted.
+func (r *Redactor) IsSecretHeader(name string) bool {
+	for _, h := range r.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSecretParam - reports whether values of the named query parameter are
+// redacted.
+func (r *Redactor) IsSecretParam(name string) bool {
+	for _, p := range r.QueryParams {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL - returns the string form of u with secret query parameter values and
+// any userinfo password replaced.  Other parameters keep their original
+// encoding and order.
+func (r *Redactor) URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	ru := *u
+	if ru.User != nil {
+		if _, ok := ru.User.Password(); ok {
+			ru.User = url.UserPassword(ru.User.Username(), r.replacement())
+		}
+	}
+	ru.RawQuery = r.RawQuery(ru.RawQuery)
+	return ru.String()
+}
+
+// RawQuery - returns rawQuery with secret parameter values replaced.
+func (r *Redactor) RawQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		name, _, hasValue := strings.Cut(pair, "=")
+		if uname, err := url.QueryUnescape(name); err == nil {
+			name = uname
+		}
+		if hasValue && r.IsSecretParam(name) {
+			pairs[i] = pair[:strings.IndexByte(pair, '=')+1] + url.QueryEscape(r.replacement())
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Header - returns a copy of h with secret header values replaced.
+func (r *Redactor) Header(h http.Header) http.Header {
+	rh := make(http.Header, len(h))
+	for k, vals := range h {
+		if r.IsSecretHeader(k) {
+			redacted := make([]string, len(vals))
+			for i := range redacted {
+				redacted[i] = r.replacement()
+			}
+			rh[k] = redacted
+			continue
+		}
+		rh[k] = append([]string(nil), vals...)
+	}
+	return rh
+}
+
+// IsSecretField - reports whether values of the named body field are
+// redacted.
+func (r *Redactor) IsSecretField(name string) bool {
+	for _, f := range r.BodyFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Body - returns body with the values of secret fields replaced, for json
+// and x-www-form-urlencoded bodies as given by contentType.  Other bodies,
+// and bodies without any secret fields, are returned unchanged.
+func (r *Redactor) Body(contentType string, body []byte) []byte {
+	if len(body) == 0 || len(r.BodyFields) == 0 {
+		return body
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == mediaTypeForm:
+		fr := &Redactor{QueryParams: r.BodyFields, Replacement: r.Replacement}
+		return []byte(fr.RawQuery(string(body)))
+
+	case mt == mediaTypeJSON || strings.HasSuffix(mt, "+json"):
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return body
+		}
+		if !r.redactJSON(v) {
+			return body
+		}
+		redacted, err := json.Marshal(v)
+		if err != nil {
+			return body
+		}
+		return redacted
+	}
+	return body
+}
+
+// redactJSON - replaces secret members throughout v, reporting whether
+// anything was replaced.
+func (r *Redactor) redactJSON(v interface{}) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, mv := range t {
+			if r.IsSecretField(k) {
+				t[k] = r.replacement()
+				changed = true
+				continue
+			}
+			changed = r.redactJSON(mv) || changed
+		}
+	case []interface{}:
+		for _, ev := range t {
+			changed = r.redactJSON(ev) || changed
+		}
+	}
+	return changed
+}
+
+// redactor - the client's Redactor, or DefaultRedactor.
+func (cl *Client) redactor() *Redactor {
+	if cl.Redactor != nil {
+		return cl.Redactor
+	}
+	return DefaultRedactor
+}
+
+// redactURLString - parses and redacts rawURL, falling back to dropping the
+// query entirely if it doesn't parse.
+func (cl *Client) redactURLString(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+			return rawURL[:i]
+		}
+		return rawURL
+	}
+	return cl.redactor().URL(u)
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/google/go-querystring/query"
 	"github.com/spkg/bom"
+	"google.golang.org/protobuf/proto"
 )
 
 var validate *validator.Validate
@@ -62,6 +63,85 @@ type Client struct {
 
 	// SkipValidate - setting this to true bypasses validator run.
 	SkipValidate bool
+
+	// ProtoJSON - request and response bodies implementing proto.Message
+	// are sent with the protobuf wire format as application/x-protobuf by
+	// default.  Setting this to true uses the protojson mapping and
+	// application/json instead.
+	ProtoJSON bool
+
+	// RequestCompression - set to EncodingGzip or EncodingZstd to compress
+	// request bodies, with a matching Content-Encoding header.  Empty
+	// (default) sends bodies uncompressed.
+	RequestCompression string
+
+	// CompressionThreshold - request bodies smaller than this many bytes
+	// are never compressed.  Zero means DefaultCompressionThreshold.
+	CompressionThreshold int
+
+	// DecompressResponses - setting this to true advertises gzip, zstd and
+	// brotli in Accept-Encoding, and transparently decodes responses using
+	// any of them.
+	DecompressResponses bool
+
+	// Redactor - decides which headers and query parameters are scrubbed
+	// from error messages.  nil means DefaultRedactor.
+	Redactor *Redactor
+
+	// Retry - the retry policy for all requests.  nil (default) makes a
+	// single attempt.
+	Retry *RetryPolicy
+
+	// Codec - when set, encodes all request bodies and decodes all
+	// response bodies, in place of the FormEncodedBody and protobuf
+	// handling.
+	Codec Codec
+
+	// Hedge - the hedging policy for all requests.  nil (default) never
+	// hedges.
+	Hedge *HedgePolicy
+
+	// HAR - when set, every attempt is recorded to it as a HAR entry,
+	// redacted with Redactor.
+	HAR *HARRecorder
+
+	// DebugErrors - setting this to true attaches a curl command line and
+	// a wire dump of the request to every RequestError from a request that
+	// was built, see RequestError.Curl.  Both are redacted with Redactor.
+	DebugErrors bool
+
+	// IdempotencyKeys - setting this to true gives every POST and PATCH an
+	// Idempotency-Key header, generated once per call and sent unchanged
+	// on every attempt, which makes them eligible for retries.  See
+	// WithIdempotencyKey to supply the key yourself.
+	IdempotencyKeys bool
+
+	// Limiter - when set, every attempt must be admitted by it before it
+	// is sent, see Bulkhead.
+	Limiter Limiter
+
+	// Coalesce - setting this to true makes concurrent identical GET and
+	// HEAD requests share one call, each caller decoding its own copy of
+	// the response.  Callers joining a call share its outcome, including
+	// the first caller's cancellation or timeout.
+	Coalesce bool
+
+	// CoalesceHeaders - the request headers that must match for requests
+	// to be coalesced.  nil means all of them.  Accept, Authorization,
+	// Proxy-Authorization, Cookie and the Redactor's secret headers must
+	// always match.
+	CoalesceHeaders []string
+
+	// flights - the calls in flight, for Coalesce.
+	flights coalescer
+
+	// errorBodies - error body types keyed by media type, see
+	// RegisterErrorBody.
+	errorBodies map[string]reflect.Type
+
+	// statusErrors - error body types keyed by status code range, see
+	// RegisterStatusError.
+	statusErrors []statusError
 }
 
 // ClientConfig - this configures an Client.  This is meant to be easily
@@ -87,6 +167,27 @@ type ClientConfig struct {
 	// StripBOM - if true, this strips the byte order markings which will otherwise bork the json decoder.
 	StripBOM bool
 
+	// ProtoJSON - if true, proto.Message bodies are encoded with protojson rather than the wire format.
+	ProtoJSON bool
+
+	// RequestCompression - "gzip" or "zstd" to compress request bodies, empty for none.
+	RequestCompression string
+
+	// CompressionThreshold - minimum request body size in bytes before compression is applied.
+	CompressionThreshold int
+
+	// DecompressResponses - if true, accept and decode gzip, zstd and brotli responses.
+	DecompressResponses bool
+
+	// DebugErrors - if true, errors carry a redacted curl command and dump of the failed request.
+	DebugErrors bool
+
+	// IdempotencyKeys - if true, POST and PATCH requests get an Idempotency-Key and become retryable.
+	IdempotencyKeys bool
+
+	// Coalesce - if true, concurrent identical GET and HEAD requests share one call.
+	Coalesce bool
+
 	// FixupCallback - this is a method that will get called before every request
 	// so that you can, for instance, manipulate headers for auth purposes, for
 	// instance.
@@ -111,10 +212,17 @@ func NewClient(cfg *ClientConfig, transport http.RoundTripper) (*Client, error)
 	}
 
 	c := &Client{
-		SkipValidate:       cfg.SkipValidate,
-		rawValidatorErrors: cfg.RawValidatorErrors,
-		StripBOM:           cfg.StripBOM,
-		FixupCallback:      cfg.FixupCallback,
+		SkipValidate:         cfg.SkipValidate,
+		rawValidatorErrors:   cfg.RawValidatorErrors,
+		StripBOM:             cfg.StripBOM,
+		FixupCallback:        cfg.FixupCallback,
+		ProtoJSON:            cfg.ProtoJSON,
+		RequestCompression:   cfg.RequestCompression,
+		CompressionThreshold: cfg.CompressionThreshold,
+		DecompressResponses:  cfg.DecompressResponses,
+		DebugErrors:          cfg.DebugErrors,
+		IdempotencyKeys:      cfg.IdempotencyKeys,
+		Coalesce:             cfg.Coalesce,
 	}
 
 	if transport == nil {
@@ -194,7 +302,8 @@ func defConfig() *ClientConfig {
 // Get - makes an http GET request to baseURL with path appended, and queryStruct optionally
 // parsed by go-querystring and validated with go-playground/validator.v9.  Upon successful
 // request, response is unmarshaled as json into responseBody, unless responseBody implements
-// CustomDecoder, in which case Decode() is called.
+// CustomDecoder, in which case Decode() is called.  A responseBody implementing proto.Message
+// is decoded as protobuf, see Client.ProtoJSON.
 func (cl *Client) Get(ctx context.Context, baseURL *url.URL, path string, queryStruct interface{}, responseBody interface{}) error {
 	_, err := cl.Req(ctx, baseURL, "GET", path, queryStruct, nil, responseBody)
 	return err
@@ -254,104 +363,237 @@ func (cl *Client) Req(ctx context.Context, baseURL *url.URL, method, path string
 }
 
 // ReqWithHeaders - this is Req allowing you to specify custom headers.
+// The request URL is built from baseURL and path as described for JoinURL.
+// Errors returned are always *RequestError, see that type for how to get at
+// the underlying ValidationErrors or ResponseError.
 func (cl *Client) ReqWithHeaders(ctx context.Context, baseURL *url.URL, method, path string,
 	queryStruct, requestBody, responseBody interface{}, headers http.Header) (*http.Response, error) {
-	finurl := baseURL.String()
-	if path != "" {
-		path = strings.TrimLeft(path, "/")
-		finurl = baseURL.String() + "/" + path
+	req, err := cl.NewRequest(ctx, baseURL, method, path, queryStruct, requestBody, headers)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Do(req, responseBody)
+}
+
+// NewRequest - this is the first half of ReqWithHeaders.  It runs
+// validation, path expansion, query and body encoding, sets the default
+// headers and calls FixupCallback, but doesn't send anything.  The body is
+// encoded up front, so the returned request has GetBody set and can be
+// inspected, signed, queued or sent more than once.  Send it with Do.
+func (cl *Client) NewRequest(ctx context.Context, baseURL *url.URL, method, path string,
+	queryStruct, requestBody interface{}, headers http.Header) (*http.Request, error) {
+	opts := optionsFromContext(ctx)
+	expanded, route, perr := cl.expandPathFromContext(ctx, path)
+	if route != "" {
+		ctx = context.WithValue(ctx, routeKey{}, route)
+	}
+	finurl := path
+	if baseURL != nil {
+		finurl = baseURL.String()
+	}
+	fail := func(phase Phase, err error) error {
+		return cl.requestError(method, finurl, route, 1, phase, err)
+	}
+	reqURL, err := JoinURL(baseURL, expanded)
+	if err != nil {
+		return nil, fail(PhaseEncode, err)
+	}
+	finurl = reqURL.String()
+	if perr != nil {
+		return nil, fail(PhaseValidate, perr)
 	}
 	if !isNil(queryStruct) {
 		if !cl.SkipValidate {
 			err := cl.validate(queryStruct)
 			if err != nil {
-				return nil, err
+				return nil, fail(PhaseValidate, err)
 			}
 		}
 		v, err := query.Values(queryStruct)
 		if err != nil {
-			return nil, err
+			return nil, fail(PhaseEncode, err)
 		}
 
-		qs := v.Encode()
-
-		if qs != "" {
-			if strings.Contains(finurl, "?") {
-				finurl = finurl + "&" + qs
-			} else {
-				finurl = finurl + "?" + qs
-			}
-		}
+		reqURL.RawQuery = joinRawQuery(reqURL.RawQuery, v.Encode())
+		finurl = reqURL.String()
 	}
 
 	var bodyReader io.Reader
 	var contentLength int64
+	var contentEncoding string
+	contentType := cl.defaultContentType(opts)
 	if !isNil(requestBody) {
 		if !cl.SkipValidate {
 
 			err := cl.validate(requestBody)
 			if err != nil {
-				return nil, err
+				return nil, fail(PhaseValidate, err)
 			}
 		}
-		if cl.FormEncodedBody {
-			v, err := query.Values(requestBody)
-			if err != nil {
-				return nil, err
-			}
-
-			rawBody := v.Encode()
-			contentLength = int64(len(rawBody))
-			bodyReader = strings.NewReader(rawBody)
-		} else {
-			bjson, err := json.Marshal(requestBody)
-			if err != nil {
-				return nil, err
-			}
-			bodyReader = bytes.NewReader(bjson)
-			contentLength = int64(len(bjson))
+		rawBody, ct, err := cl.encodeBody(opts, requestBody)
+		if err != nil {
+			return nil, fail(PhaseEncode, err)
 		}
+		contentType = ct
+		rawBody, compressed, err := cl.compressBody(rawBody)
+		if err != nil {
+			return nil, fail(PhaseEncode, err)
+		}
+		if compressed {
+			contentEncoding = cl.RequestCompression
+		}
+		bodyReader = bytes.NewReader(rawBody)
+		contentLength = int64(len(rawBody))
 	}
-	req, err := http.NewRequest(method, finurl, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, finurl, bodyReader)
 	if err != nil {
-		return nil, err
+		return nil, fail(PhaseEncode, err)
 	}
 
-	req = req.WithContext(ctx)
-
 	for k, h := range headers {
 		req.Header[k] = h
 	}
 	req.ContentLength = contentLength
 	if req.Header.Get("Content-Type") == "" {
-		if cl.FormEncodedBody {
-			req.Header["Content-Type"] = []string{"application/x-www-form-urlencoded"}
-		} else {
-			req.Header["Content-Type"] = []string{"application/json"}
-		}
+		req.Header["Content-Type"] = []string{contentType}
+	}
+	if contentEncoding != "" {
+		req.Header["Content-Encoding"] = []string{contentEncoding}
 	}
+	if cl.DecompressResponses && req.Header.Get("Accept-Encoding") == "" {
+		req.Header["Accept-Encoding"] = []string{acceptEncoding}
+	}
+	cl.setIdempotencyKey(req)
 
+	if !fixupsAllowed(ctx) {
+		return req, nil
+	}
 	if cl.FixupCallback != nil {
 		err = cl.FixupCallback(req)
 		if err != nil {
-			return nil, err
+			return nil, fail(PhaseEncode, err)
 		}
 	}
-	resp, err := cl.Client.Do(req)
+	if opts != nil && opts.auth != nil {
+		err = opts.auth(req)
+		if err != nil {
+			return nil, fail(PhaseEncode, err)
+		}
+	}
+	return req, nil
+}
+
+// Do - this is the second half of ReqWithHeaders.  It sends req, normally
+// built by NewRequest, retrying according to the retry policy in effect,
+// and decodes the response into responseBody.  req itself is never sent or
+// modified; each attempt sends a clone with a fresh body from GetBody.  The
+// response is returned with its body read and closed, and errors are
+// *RequestError, exactly as for ReqWithHeaders.
+func (cl *Client) Do(req *http.Request, responseBody interface{}) (*http.Response, error) {
+	if cl.Coalesce && coalescable(req) {
+		return cl.coalescedDo(req, responseBody)
+	}
+	return cl.do(req, responseBody)
+}
+
+// do - the retry loop behind Do.
+func (cl *Client) do(req *http.Request, responseBody interface{}) (*http.Response, error) {
+	ctx := req.Context()
+	opts := optionsFromContext(ctx)
+	if opts != nil && opts.timeout > 0 {
+		// The body is read and closed before returning, so the timeout
+		// can end with the call.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	policy := cl.retryPolicy(opts)
+	for attempt := 1; ; attempt++ {
+		resp, err := cl.send(req, responseBody, attempt)
+		if err == nil || !policy.shouldRetry(req, attempt, err) {
+			return resp, err
+		}
+		t := time.NewTimer(policy.backoff(attempt, err))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return resp, err
+		case <-t.C:
+		}
+	}
+}
+
+// send - makes a single attempt at req.
+func (cl *Client) send(req *http.Request, responseBody interface{}, attempt int) (*http.Response, error) {
+	ctx := req.Context()
+	opts := optionsFromContext(ctx)
+	fail := func(phase Phase, err error) error {
+		return cl.debugError(cl.requestError(req.Method, req.URL.String(), RouteFromContext(ctx), attempt, phase, err), req)
+	}
+
+	// Every attempt gets its own copy of the request and body.
+	areq := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fail(PhaseEncode, err)
+		}
+		areq.Body = body
+	}
+	if _, ok := responseBody.(proto.Message); ok && cl.codec(opts) == nil && areq.Header.Get("Accept") == "" {
+		areq.Header["Accept"] = []string{cl.protoMediaType()}
+	}
+
+	// A request the Limiter rejects is never sent, so isn't recorded.
+	done, err := cl.acquire(areq)
 	if err != nil {
-		return nil, err
+		return nil, fail(PhaseTransport, err)
+	}
+	var hx *harExchange
+	if cl.HAR != nil {
+		hx = cl.startHAR(areq, attempt)
+		areq = hx.req
+	}
+	resp, wx, err := cl.roundTrip(areq, hx)
+	hx = wx
+	if err != nil {
+		done(nil, err)
+		if hx != nil {
+			cl.finishHAR(hx, nil, err)
+		}
+		return nil, fail(PhaseTransport, err)
 	}
 
+	rawRespBody := resp.Body
 	defer func() {
+		if hx != nil {
+			// Read what the caller didn't, so the recorded size is right.
+			io.Copy(ioutil.Discard, resp.Body)
+			cl.finishHAR(hx, resp, nil)
+		}
 		// Throw away any remainder of the body so pooling works.
-		io.Copy(ioutil.Discard, resp.Body)
+		io.Copy(ioutil.Discard, rawRespBody)
 		_ = resp.Body.Close()
+		done(resp, nil)
 	}()
-	if resp.StatusCode >= 400 {
-		if cl.ErrorResponseCallback != nil {
+	if cl.DecompressResponses {
+		if opts.unexpectedStatus(resp.StatusCode) {
+			// An unexpected status takes precedence over a body that
+			// can't be decoded, which is then kept as it came.
+			decompressErrorResponse(resp)
+		} else if err = decompressResponse(resp); err != nil {
+			return resp, fail(PhaseDecode, err)
+		}
+	}
+	if hx != nil {
+		resp.Body = hx.captureBody(resp.Body)
+	}
+	if opts.unexpectedStatus(resp.StatusCode) {
+		if cl.ErrorResponseCallback != nil && resp.StatusCode >= 400 {
 			err = cl.ErrorResponseCallback(resp)
 			if err != nil {
-				return resp, err
+				return resp, fail(PhaseStatus, err)
 			}
 		} else {
 			body, _ := ioutil.ReadAll(resp.Body)
@@ -361,23 +603,41 @@ func (cl *Client) ReqWithHeaders(ctx context.Context, baseURL *url.URL, method,
 				ResponseBody: body,
 				Header:       resp.Header,
 			}
-			return resp, rs
+			rs.ErrorBody = cl.decodeErrorBody(resp, body)
+			return resp, fail(PhaseStatus, rs)
 		}
 	}
 	if isNil(responseBody) {
 		return resp, nil
 	}
-	var reader io.Reader = resp.Body
+	err = cl.decodeBody(resp, resp.Body, responseBody)
+	if err != nil {
+		return resp, fail(PhaseDecode, err)
+	}
+	return resp, nil
+}
 
+// decodeBody - decodes r, which is the body of resp, into v.  v's
+// CustomDecoder takes priority, then the codec in effect for the request,
+// then proto.Message, otherwise json is used.
+func (cl *Client) decodeBody(resp *http.Response, r io.Reader, v interface{}) error {
 	if cl.StripBOM {
-		reader = bom.NewReader(resp.Body)
+		r = bom.NewReader(r)
 	}
 
-	if cd, ok := responseBody.(CustomDecoder); ok {
-		return resp, cd.Decode(reader)
+	if cd, ok := v.(CustomDecoder); ok {
+		return cd.Decode(r)
 	}
 
-	return resp, json.NewDecoder(reader).Decode(responseBody)
+	if ok, err := cl.decodeWithCodec(resp, r, v); ok {
+		return err
+	}
+
+	if pm, ok := v.(proto.Message); ok {
+		return cl.unmarshalProto(resp, r, pm)
+	}
+
+	return json.NewDecoder(r).Decode(v)
 }
 
 // ValidationErrors - this is a thin wrapper around the validator
@@ -529,12 +789,45 @@ func (bc *BaseClient) ReqWithHeaders(ctx context.Context, method, path string, q
 	return bc.Client.ReqWithHeaders(ctx, bc.BaseURL, method, path, queryStruct, requestBody, responseBody, headers)
 }
 
+// NewRequest - like Client.NewRequest, except uses BaseClient.BaseURL instead of needing
+// to be passed in.
+func (bc *BaseClient) NewRequest(ctx context.Context, method, path string, queryStruct,
+	requestBody interface{}, headers http.Header) (*http.Request, error) {
+	return bc.Client.NewRequest(ctx, bc.BaseURL, method, path, queryStruct, requestBody, headers)
+}
+
+// Do - like Client.Do.
+func (bc *BaseClient) Do(req *http.Request, responseBody interface{}) (*http.Response, error) {
+	return bc.Client.Do(req, responseBody)
+}
+
+// RegisterErrorBody - like Client.RegisterErrorBody.
+func (bc *BaseClient) RegisterErrorBody(mediaType string, errType error) {
+	bc.Client.RegisterErrorBody(mediaType, errType)
+}
+
+// RegisterStatusError - like Client.RegisterStatusError.
+func (bc *BaseClient) RegisterStatusError(from, to int, errType error) {
+	bc.Client.RegisterStatusError(from, to, errType)
+}
+
 // ResponseError - this is an http response error type.  returned on >=400 status code.
 type ResponseError struct {
 	Status       string
 	StatusCode   int
 	ResponseBody []byte
 	Header       http.Header
+
+	// ErrorBody - the response body decoded into the error type registered
+	// for its status code or media type, see Client.RegisterStatusError
+	// and Client.RegisterErrorBody.  This is nil when no type is
+	// registered or the body could not be decoded.
+	ErrorBody error
+}
+
+// Unwrap - allows errors.As to reach the decoded ErrorBody.
+func (rs *ResponseError) Unwrap() error {
+	return rs.ErrorBody
 }
 
 func (rs *ResponseError) Error() string {
@@ -3,6 +3,7 @@ package restclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -231,7 +232,11 @@ func TestPostSliceValidation(t *testing.T) {
 		t.Log("error was nil, should have failed validation")
 		t.Fail()
 	}
-	if err.Error() != `Validation error: Field 'DNSRecord.Type' invalid value: 'ASDF', valid values are: "A","AAAA","CNAME","MX","NS","SOA","SRV","TXT"` {
+	var ve ValidationErrors
+	if !errors.As(err, &ve) {
+		t.Log("expected ValidationErrors, got ", err)
+		t.Fail()
+	} else if ve.Error() != `Validation error: Field 'DNSRecord.Type' invalid value: 'ASDF', valid values are: "A","AAAA","CNAME","MX","NS","SOA","SRV","TXT"` {
 		t.Log("unexpected error message, got ", err)
 	}
 }
@@ -268,3 +273,70 @@ type DNSRecord struct {
 // DNSRecords represents the GoDaddy model
 // https://developer.godaddy.com/doc#!/_v1_domains/recordReplace/ArrayOfDNSRecord
 type DNSRecords []DNSRecord
+
+func TestNewRequestAndDo(t *testing.T) {
+	var calls int
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"Foo":"foo","Bar":"bar","Baz":59}`))
+	}))
+	defer srv.Close()
+
+	bc, err := NewBaseClient(srv.URL, &ClientConfig{
+		FixupCallback: func(req *http.Request) error {
+			req.Header.Set("X-Signature", "signed")
+			return nil
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqb := &testValidatorRequest{UID: "testuid", KeyType: "s3"}
+	req, err := bc.NewRequest(context.Background(), "POST", "/whatever", reqb, reqb, nil)
+	if err != nil {
+		t.Fatal("Failed NewRequest: ", err)
+	}
+	if calls != 0 {
+		t.Fatal("NewRequest should not send anything")
+	}
+	if req.URL.String() != srv.URL+"/whatever?key-type=s3&uid=testuid" {
+		t.Log("unexpected request URL ", req.URL)
+		t.Fail()
+	}
+	if req.Header.Get("X-Signature") != "signed" || req.Header.Get("Content-Type") != "application/json" {
+		t.Log("expected default headers and FixupCallback to be applied, got ", req.Header)
+		t.Fail()
+	}
+	want, _ := json.Marshal(reqb)
+	body, err := req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, _ := ioutil.ReadAll(body)
+	if string(preview) != string(want) {
+		t.Log("unexpected body preview ", string(preview))
+		t.Fail()
+	}
+
+	// The request can be sent more than once.
+	for i := 1; i <= 2; i++ {
+		tr := &testResponse{}
+		_, err = bc.Do(req, tr)
+		if err != nil {
+			t.Fatal("Failed Do: ", err)
+		}
+		if calls != i || string(gotBody) != string(want) || tr.Baz != 59 {
+			t.Logf("send %d: unexpected result %d %s %#v", i, calls, gotBody, tr)
+			t.Fail()
+		}
+	}
+
+	_, err = bc.NewRequest(context.Background(), "POST", "/whatever", nil, &testValidatorRequest{}, nil)
+	var ve ValidationErrors
+	if !errors.As(err, &ve) {
+		t.Log("expected validation error from NewRequest, got ", err)
+		t.Fail()
+	}
+}
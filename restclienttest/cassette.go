@@ -0,0 +1,358 @@
+package restclienttest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/myENA/restclient"
+)
+
+// Mode - what a Recorder does with each request.
+type Mode int
+
+const (
+	// ModeReplay - answer requests from the cassette only.  Requests with
+	// no recorded interaction fail with an *UnrecordedError.
+	ModeReplay Mode = iota
+
+	// ModeRecord - send requests to the real server and record every
+	// interaction, replacing the cassette on Save.
+	ModeRecord
+
+	// ModePassthrough - send requests to the real server without recording
+	// anything.
+	ModePassthrough
+)
+
+// Cassette - the on-disk form of a set of recorded interactions.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction - a single recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest - a request as stored in a cassette, with secrets
+// already redacted.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// RecordedResponse - a response as stored in a cassette, with secrets
+// already redacted.
+type RecordedResponse struct {
+	Status     string      `json:"status"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body - a recorded body.  It is stored as a json string when it is valid
+// utf-8, so cassettes stay readable and diffable, and as base64 otherwise.
+type Body []byte
+
+// MarshalJSON - implements json.Marshaler.
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON - implements json.Unmarshaler.
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var enc map[string]string
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc["base64"])
+	if err != nil {
+		return err
+	}
+	*b = raw
+	return nil
+}
+
+// Matcher - decides whether a live request, already redacted the same way
+// as the cassette, matches a recorded one.
+type Matcher func(live, recorded *RecordedRequest) bool
+
+// MatchMethod - matches on the http method.
+func MatchMethod(live, recorded *RecordedRequest) bool {
+	return live.Method == recorded.Method
+}
+
+// MatchURL - matches on the full URL, including the query.
+func MatchURL(live, recorded *RecordedRequest) bool {
+	return live.URL == recorded.URL
+}
+
+// MatchBody - matches on the request body.
+func MatchBody(live, recorded *RecordedRequest) bool {
+	return bytes.Equal(live.Body, recorded.Body)
+}
+
+// MatchHeaders - returns a Matcher comparing the named headers.
+func MatchHeaders(names ...string) Matcher {
+	return func(live, recorded *RecordedRequest) bool {
+		for _, name := range names {
+			if strings.Join(live.Header.Values(name), ",") != strings.Join(recorded.Header.Values(name), ",") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DefaultMatchers - the matchers used when Recorder.Matchers is nil.
+var DefaultMatchers = []Matcher{MatchMethod, MatchURL}
+
+// UnrecordedError - returned in ModeReplay for a request with no matching
+// interaction in the cassette.
+type UnrecordedError struct {
+	Cassette string
+	Method   string
+	URL      string
+}
+
+func (e *UnrecordedError) Error() string {
+	return fmt.Sprintf("restclienttest: no unused interaction in cassette %s matches %s %s; re-record it with ModeRecord",
+		e.Cassette, e.Method, e.URL)
+}
+
+// Recorder - an http.RoundTripper that records and replays interactions,
+// for use as the transport argument to restclient.NewClient:
+//
+//	rec, err := restclienttest.NewRecorder("testdata/users.json", restclienttest.ModeReplay)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Save()
+//	bc, err := restclient.NewBaseClient("https://api.example.com", nil, rec)
+//
+// Secrets are removed with Redactor before anything is written, and live
+// requests are redacted the same way before matching, so requests carrying
+// real credentials still match their recordings.  In ModeReplay each
+// recorded interaction answers one request, in order.
+type Recorder struct {
+	// Transport - the transport used to reach the real server in
+	// ModeRecord and ModePassthrough.  nil means http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Matchers - all of these must agree for a recorded interaction to
+	// answer a request.  nil means DefaultMatchers.
+	Matchers []Matcher
+
+	// Redactor - removes secrets from recorded requests and responses.
+	// nil means restclient.DefaultRedactor.
+	Redactor *restclient.Redactor
+
+	path     string
+	mode     Mode
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewRecorder - creates a Recorder for the cassette at path.  In ModeReplay
+// the cassette must already exist.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		mode:     mode,
+		cassette: &Cassette{},
+	}
+	if mode != ModeReplay {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("restclienttest: cannot load cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, r.cassette); err != nil {
+		return nil, fmt.Errorf("restclienttest: invalid cassette %s: %w", path, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Save - writes the recorded interactions to the cassette file.  This only
+// does anything in ModeRecord.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+// RoundTrip - implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch r.mode {
+	case ModePassthrough:
+		return r.transport().RoundTrip(req)
+	case ModeRecord:
+		return r.record(req)
+	}
+	return r.replay(req)
+}
+
+func (r *Recorder) transport() http.RoundTripper {
+	if r.Transport != nil {
+		return r.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (r *Recorder) redactor() *restclient.Redactor {
+	if r.Redactor != nil {
+		return r.Redactor
+	}
+	return restclient.DefaultRedactor
+}
+
+// redactRequest - reads the body of req, returning the redacted form that
+// is stored and matched on, and a clone of req to send in its place, since
+// a RoundTripper mustn't modify the request it is given.  Compressed
+// bodies are decoded before redaction, and recorded decoded.
+func (r *Recorder) redactRequest(req *http.Request) (*RecordedRequest, *http.Request, error) {
+	var body []byte
+	out := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	header, body, err := restclient.Decompress(req.Header, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot record compressed request body: %w", err)
+	}
+	rd := r.redactor()
+	return &RecordedRequest{
+		Method: req.Method,
+		URL:    rd.URL(req.URL),
+		Header: rd.Header(header),
+		Body:   rd.Body(header.Get("Content-Type"), body),
+	}, out, nil
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	rr, out, err := r.redactRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.transport().RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// The caller gets the response as sent; the cassette gets it decoded,
+	// so the redactor can see inside it.
+	header, decoded, err := restclient.Decompress(resp.Header, body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot record compressed response body: %w", err)
+	}
+	rd := r.redactor()
+	in := &Interaction{
+		Request: *rr,
+		Response: RecordedResponse{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Header:     rd.Header(header),
+			Body:       rd.Body(header.Get("Content-Type"), decoded),
+		},
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	live, _, err := r.redactRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	matchers := r.Matchers
+	if matchers == nil {
+		matchers = DefaultMatchers
+	}
+
+	r.mu.Lock()
+	var found *Interaction
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !matchesAll(matchers, live, &in.Request) {
+			continue
+		}
+		r.used[i] = true
+		found = in
+		break
+	}
+	r.mu.Unlock()
+	if found == nil {
+		return nil, &UnrecordedError{Cassette: r.path, Method: live.Method, URL: live.URL}
+	}
+
+	header := found.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        found.Response.Status,
+		StatusCode:    found.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(found.Response.Body)),
+		ContentLength: int64(len(found.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func matchesAll(matchers []Matcher, live, recorded *RecordedRequest) bool {
+	for _, m := range matchers {
+		if !m(live, recorded) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package restclienttest
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/myENA/restclient"
+)
+
+type testLogin struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	srv.Handle("POST", "/login").
+		ReplyHeader("Content-Type", "application/json").
+		Reply(200, `{"token":"s3cret","user":"bob"}`)
+	srv.Handle("GET", "/users/{id}").Reply(200, &testUser{ID: "59", Name: "bob"})
+
+	path := filepath.Join(t.TempDir(), "cassettes", "users.json")
+	rec, err := NewRecorder(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := restclient.NewBaseClient(srv.URL, nil, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := &testLogin{User: "bob", Password: "hunter2"}
+	if err := bc.Post(context.Background(), "/login?api_key=k1", nil, login, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := &testUser{}
+	if err := bc.Get(context.Background(), "/users/59", nil, out); err != nil || out.Name != "bob" {
+		t.Fatalf("unexpected recorded response %v %v", out, err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cret", "k1"} {
+		if strings.Contains(string(data), secret) {
+			t.Logf("secret %s leaked into cassette: %s", secret, data)
+			t.Fail()
+		}
+	}
+
+	// Replay with different credentials and no server at all.
+	srv.Close()
+	rec, err = NewRecorder(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Matchers = append(DefaultMatchers, MatchBody)
+	bc, err = restclient.NewBaseClient(srv.URL, nil, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login.Password = "other"
+	if err := bc.Post(context.Background(), "/login?api_key=k2", nil, login, nil); err != nil {
+		t.Fatal(err)
+	}
+	out = &testUser{}
+	if err := bc.Get(context.Background(), "/users/59", nil, out); err != nil || out.Name != "bob" {
+		t.Logf("unexpected replayed response %v %v", out, err)
+		t.Fail()
+	}
+
+	err = bc.Get(context.Background(), "/users/59", nil, out)
+	var ue *UnrecordedError
+	if !errors.As(err, &ue) || ue.Method != "GET" {
+		t.Log("expected UnrecordedError for used up interaction, got ", err)
+		t.Fail()
+	}
+}
+
+func TestRecorderCompressed(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(`{"token":"s3cret","user":"bob"}`))
+		gw.Close()
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "compressed.json")
+	rec, err := NewRecorder(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := restclient.NewBaseClient(srv.URL, &restclient.ClientConfig{
+		RequestCompression:  restclient.EncodingGzip,
+		DecompressResponses: true,
+	}, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := &testLogin{User: "bob", Password: strings.Repeat("hunter2", 200)}
+	if err := bc.Post(context.Background(), "/login", nil, login, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Contains(string(data), "s3cret") ||
+		!strings.Contains(string(data), "bob") {
+		t.Log("expected decoded, redacted bodies in the cassette: ", string(data))
+		t.Fail()
+	}
+}
+
+func TestRecorderLeavesRequestAlone(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	route := srv.Handle("POST", "/things").Reply(201, nil)
+
+	rec, err := NewRecorder(filepath.Join(t.TempDir(), "things.json"), ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := io.NopCloser(strings.NewReader(`{"a":1}`))
+	req, _ := http.NewRequest("POST", srv.URL+"/things", body)
+	req.Body = body
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Body != body {
+		t.Log("RoundTrip replaced the request body")
+		t.Fail()
+	}
+	route.LastRequest().AssertJSONBody(`{"a":1}`)
+}
+
+func TestBody(t *testing.T) {
+	t.Parallel()
+	for _, b := range []Body{Body("plain text"), Body{0xff, 0x00, 0xfe}} {
+		data, err := b.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Body
+		if err := got.UnmarshalJSON(data); err != nil || string(got) != string(b) {
+			t.Logf("body did not round trip: %q %s %v", b, data, err)
+			t.Fail()
+		}
+	}
+}
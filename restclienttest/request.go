@@ -0,0 +1,278 @@
+package restclienttest
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-querystring/query"
+)
+
+// Request - a request captured by the Server.
+type Request struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+
+	// PathParams - the values matched by {name} placeholders in the route.
+	PathParams map[string]string
+
+	t testing.TB
+}
+
+// DecodeJSON - decodes the request body into v.
+func (r *Request) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// DecodeQuery - decodes the query string back into v, which must be a
+// pointer to a struct using the same `url` tags go-querystring encodes
+// with.  Strings, bools, numbers, encoding.TextUnmarshaler implementations
+// such as time.Time, pointers to those and slices of those are supported,
+// including the comma, space, semicolon and brackets slice options.
+func (r *Request) DecodeQuery(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeQuery requires a pointer to a struct, got %T", v)
+	}
+	return decodeQuery(r.URL.Query(), rv.Elem())
+}
+
+// AssertMethod - fails the test if the request method isn't method.
+func (r *Request) AssertMethod(method string) {
+	r.t.Helper()
+	if !strings.EqualFold(r.Method, method) {
+		r.t.Errorf("restclienttest: expected method %s, got %s", method, r.Method)
+	}
+}
+
+// AssertPath - fails the test if the request path isn't path.  path is
+// compared with the escaped form of the request path.
+func (r *Request) AssertPath(path string) {
+	r.t.Helper()
+	if got := r.URL.EscapedPath(); got != path {
+		r.t.Errorf("restclienttest: expected path %s, got %s", path, got)
+	}
+}
+
+// AssertHeader - fails the test if the request header key isn't value.
+func (r *Request) AssertHeader(key, value string) {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != value {
+		r.t.Errorf("restclienttest: expected header %s %q, got %q", key, value, got)
+	}
+}
+
+// AssertQuery - fails the test unless the query string decodes into a
+// value equal to want, a struct or pointer to struct with `url` tags.  Only
+// the fields of want are compared, so unrelated query parameters are
+// ignored.
+func (r *Request) AssertQuery(want interface{}) {
+	r.t.Helper()
+	wv := reflect.ValueOf(want)
+	for wv.Kind() == reflect.Ptr {
+		wv = wv.Elem()
+	}
+	got := reflect.New(wv.Type())
+	if err := r.DecodeQuery(got.Interface()); err != nil {
+		r.t.Errorf("restclienttest: cannot decode query %q: %s", r.URL.RawQuery, err)
+		return
+	}
+	if !reflect.DeepEqual(got.Elem().Interface(), wv.Interface()) {
+		wantQS, _ := query.Values(want)
+		r.t.Errorf("restclienttest: expected query %s, got %s", wantQS.Encode(), r.URL.RawQuery)
+	}
+}
+
+// AssertJSONBody - fails the test unless the request body is json equal to
+// want.  want is marshaled and both sides are compared as generic json, so
+// key order and whitespace don't matter.  want may also be a json string or
+// []byte.
+func (r *Request) AssertJSONBody(want interface{}) {
+	r.t.Helper()
+	var wantJSON []byte
+	switch v := want.(type) {
+	case []byte:
+		wantJSON = v
+	case string:
+		wantJSON = []byte(v)
+	default:
+		var err error
+		wantJSON, err = json.Marshal(want)
+		if err != nil {
+			r.t.Errorf("restclienttest: cannot encode expected body: %s", err)
+			return
+		}
+	}
+	var wantAny, gotAny interface{}
+	if err := json.Unmarshal(wantJSON, &wantAny); err != nil {
+		r.t.Errorf("restclienttest: expected body is not json: %s", err)
+		return
+	}
+	if err := json.Unmarshal(r.Body, &gotAny); err != nil {
+		r.t.Errorf("restclienttest: request body is not json: %s: %s", err, r.Body)
+		return
+	}
+	if !reflect.DeepEqual(wantAny, gotAny) {
+		r.t.Errorf("restclienttest: expected json body %s, got %s", wantJSON, r.Body)
+	}
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func decodeQuery(vals url.Values, sv reflect.Value) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+		name, optStr, _ := strings.Cut(tag, ",")
+		opts := strings.Split(optStr, ",")
+		fv := sv.Field(i)
+
+		if name == "" && f.Anonymous && indirectType(f.Type).Kind() == reflect.Struct {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(f.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := decodeQuery(vals, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		raw, ok := queryValues(vals, name, fv.Type(), opts)
+		if !ok {
+			continue
+		}
+		if err := setField(fv, raw, opts); err != nil {
+			return fmt.Errorf("field %s: %s", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// queryValues - gathers the raw values for a field, splitting delimited
+// slices back apart.
+func queryValues(vals url.Values, name string, t reflect.Type, opts []string) ([]string, bool) {
+	it := indirectType(t)
+	isSlice := it.Kind() == reflect.Slice && !reflect.PointerTo(it).Implements(textUnmarshalerType)
+	if isSlice && hasOpt(opts, "brackets") {
+		name += "[]"
+	}
+	raw, ok := vals[name]
+	if !ok || !isSlice || len(raw) != 1 {
+		return raw, ok
+	}
+	for opt, sep := range map[string]string{"comma": ",", "space": " ", "semicolon": ";"} {
+		if hasOpt(opts, opt) {
+			return strings.Split(raw[0], sep), true
+		}
+	}
+	return raw, true
+}
+
+func hasOpt(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func setField(fv reflect.Value, raw []string, opts []string) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setField(fv.Elem(), raw, opts)
+	}
+	if fv.Kind() == reflect.Slice && !reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+		for i, r := range raw {
+			if err := setScalar(s.Index(i), r, opts); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+		return nil
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	return setScalar(fv, raw[0], opts)
+}
+
+func setScalar(fv reflect.Value, s string, opts []string) error {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+	if fv.CanAddr() {
+		if tu, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return tu.UnmarshalText([]byte(s))
+		}
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if hasOpt(opts, "int") {
+			fv.SetBool(s != "0")
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+// Package restclienttest - provides a programmable fake http server for
+// testing code built on restclient.  Register routes with canned responses
+// or handler funcs, point a BaseClient at the server, and then assert on the
+// requests it captured:
+//
+//	func TestCreateUser(t *testing.T) {
+//		srv := restclienttest.NewServer(t)
+//		route := srv.Handle("POST", "/users/{id}").Reply(201, &User{ID: "59"})
+//
+//		err := CreateUser(srv.BaseClient(nil), "59")
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//
+//		req := route.LastRequest()
+//		req.AssertQuery(&CreateUserQuery{Notify: true})
+//		req.AssertJSONBody(&User{ID: "59"})
+//	}
+//
+// Every Server has its own state behind a mutex, so tests using separate
+// servers can run in parallel.
+package restclienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/myENA/restclient"
+)
+
+// Server - a fake http server with registered routes.  The embedded
+// httptest.Server gives access to the URL and Close.
+type Server struct {
+	*httptest.Server
+
+	t        testing.TB
+	mu       sync.Mutex
+	routes   []*Route
+	requests []*Request
+}
+
+// NewServer - starts a new Server, which is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	s := &Server{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Handle - registers a route for method and path.  An empty method matches
+// any method.  path may contain {name} placeholders, which match a single
+// path segment and are available from Request.PathParams.  Routes are
+// matched in the order they were registered.  A new route replies 200 with
+// an empty body until told otherwise.
+func (s *Server) Handle(method, path string) *Route {
+	r := &Route{
+		server: s,
+		method: strings.ToUpper(method),
+		path:   path,
+		match:  compileRoute(path),
+		status: http.StatusOK,
+		header: make(http.Header),
+	}
+	s.mu.Lock()
+	s.routes = append(s.routes, r)
+	s.mu.Unlock()
+	return r
+}
+
+// BaseClient - returns a BaseClient pointed at the server.  cfg may be nil.
+func (s *Server) BaseClient(cfg *restclient.ClientConfig) *restclient.BaseClient {
+	s.t.Helper()
+	bc, err := restclient.NewBaseClient(s.URL, cfg, nil)
+	if err != nil {
+		s.t.Fatalf("restclienttest: NewBaseClient: %s", err)
+	}
+	return bc
+}
+
+// Requests - returns every request the server has received, in order,
+// including ones that matched no route.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+// Reset - forgets all captured requests and call counts, keeping the
+// registered routes.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	for _, r := range s.routes {
+		r.requests = nil
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, hr *http.Request) {
+	body, err := io.ReadAll(hr.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req := &Request{
+		Method: hr.Method,
+		URL:    hr.URL,
+		Header: hr.Header.Clone(),
+		Body:   body,
+		t:      s.t,
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var route *Route
+	for _, r := range s.routes {
+		if params, ok := r.matches(hr); ok {
+			route = r
+			req.PathParams = params
+			r.requests = append(r.requests, req)
+			break
+		}
+	}
+	var (
+		handler http.HandlerFunc
+		status  int
+		header  http.Header
+		reply   []byte
+	)
+	if route != nil {
+		handler, status, header, reply = route.handler, route.status, route.header.Clone(), route.body
+	}
+	s.mu.Unlock()
+
+	if route == nil {
+		s.t.Errorf("restclienttest: unexpected request %s %s", hr.Method, hr.URL.RequestURI())
+		http.Error(w, "restclienttest: no route for "+hr.Method+" "+hr.URL.Path, http.StatusNotFound)
+		return
+	}
+	if handler != nil {
+		// Let the handler see the body that was already read.
+		hr.Body = io.NopCloser(strings.NewReader(string(body)))
+		handler(w, hr)
+		return
+	}
+	for k, vals := range header {
+		w.Header()[k] = vals
+	}
+	w.WriteHeader(status)
+	w.Write(reply)
+}
+
+var routePlaceholder = regexp.MustCompile(`\\\{([^{}/]+)\\\}`)
+
+// compileRoute - turns a route path with {name} placeholders into a regexp.
+func compileRoute(path string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(path)
+	pattern := routePlaceholder.ReplaceAllString(quoted, `(?P<$1>[^/]+)`)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// Route - a registered route and its canned response.
+type Route struct {
+	server *Server
+	method string
+	path   string
+	match  *regexp.Regexp
+
+	// These are guarded by server.mu.
+	status   int
+	header   http.Header
+	body     []byte
+	handler  http.HandlerFunc
+	requests []*Request
+}
+
+func (r *Route) matches(hr *http.Request) (map[string]string, bool) {
+	if r.method != "" && r.method != hr.Method {
+		return nil, false
+	}
+	m := r.match.FindStringSubmatch(hr.URL.EscapedPath())
+	if m == nil {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range r.match.SubexpNames() {
+		if name != "" {
+			// Matched against the escaped path, so an encoded slash stays
+			// within its segment, then unescaped for the handler.
+			v, err := url.PathUnescape(m[i])
+			if err != nil {
+				v = m[i]
+			}
+			params[name] = v
+		}
+	}
+	return params, true
+}
+
+// Reply - sets the canned response.  body may be nil, a []byte or string
+// sent as is, or anything else, which is sent json encoded with a
+// Content-Type of application/json.
+func (r *Route) Reply(status int, body interface{}) *Route {
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		var err error
+		b, err = json.Marshal(v)
+		if err != nil {
+			r.server.t.Fatalf("restclienttest: cannot encode reply for %s %s: %s", r.method, r.path, err)
+		}
+		r.server.mu.Lock()
+		if r.header.Get("Content-Type") == "" {
+			r.header.Set("Content-Type", "application/json")
+		}
+		r.server.mu.Unlock()
+	}
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	r.status = status
+	r.body = b
+	r.handler = nil
+	return r
+}
+
+// ReplyHeader - sets a header on the canned response.
+func (r *Route) ReplyHeader(key, value string) *Route {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	r.header.Set(key, value)
+	return r
+}
+
+// HandlerFunc - answers requests to the route with h instead of a canned
+// response.  Requests are still captured.
+func (r *Route) HandlerFunc(h http.HandlerFunc) *Route {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	r.handler = h
+	return r
+}
+
+// Calls - the number of requests the route has received.
+func (r *Route) Calls() int {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	return len(r.requests)
+}
+
+// Requests - the requests the route has received, in order.
+func (r *Route) Requests() []*Request {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	return append([]*Request(nil), r.requests...)
+}
+
+// LastRequest - the most recent request to the route.  The test fails
+// immediately if there hasn't been one.
+func (r *Route) LastRequest() *Request {
+	r.server.t.Helper()
+	reqs := r.Requests()
+	if len(reqs) == 0 {
+		r.server.t.Fatalf("restclienttest: no requests to %s %s", r.method, r.path)
+	}
+	return reqs[len(reqs)-1]
+}
+
+// String - implements fmt.Stringer.
+func (r *Route) String() string {
+	return fmt.Sprintf("%s %s", r.method, r.path)
+}
@@ -0,0 +1,128 @@
+package restclienttest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/myENA/restclient"
+)
+
+type testQuery struct {
+	UID     string    `url:"uid"`
+	Limit   int       `url:"limit,omitempty"`
+	Verbose *bool     `url:"verbose,omitempty"`
+	Tags    []string  `url:"tags,comma,omitempty"`
+	IDs     []int     `url:"id,omitempty"`
+	Since   time.Time `url:"since,omitempty"`
+}
+
+type testUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	create := srv.Handle("POST", "/users/{id}").Reply(201, &testUser{ID: "59", Name: "bob"})
+	get := srv.Handle("GET", "/users/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"60","name":"alice"}`))
+	})
+
+	bc := srv.BaseClient(nil)
+	verbose := true
+	q := &testQuery{
+		UID:     "u1",
+		Limit:   10,
+		Verbose: &verbose,
+		Tags:    []string{"a", "b"},
+		IDs:     []int{1, 2},
+		Since:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	out := &testUser{}
+	err := bc.Post(context.Background(), "/users/59", q, &testUser{ID: "59", Name: "bob"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "bob" {
+		t.Log("unexpected canned response ", out)
+		t.Fail()
+	}
+
+	req := create.LastRequest()
+	req.AssertMethod("POST")
+	req.AssertPath("/users/59")
+	req.AssertHeader("Content-Type", "application/json")
+	req.AssertQuery(q)
+	req.AssertJSONBody(`{"name":"bob","id":"59"}`)
+	if req.PathParams["id"] != "59" {
+		t.Log("unexpected path params ", req.PathParams)
+		t.Fail()
+	}
+
+	err = bc.Get(context.Background(), "/users/60", nil, out)
+	if err != nil || out.Name != "alice" {
+		t.Logf("unexpected handler func response %v %v", out, err)
+		t.Fail()
+	}
+	if create.Calls() != 1 || get.Calls() != 1 || len(srv.Requests()) != 2 {
+		t.Logf("unexpected call counts %d %d %d", create.Calls(), get.Calls(), len(srv.Requests()))
+		t.Fail()
+	}
+
+	srv.Reset()
+	if create.Calls() != 0 || len(srv.Requests()) != 0 {
+		t.Log("expected Reset to clear captured requests")
+		t.Fail()
+	}
+}
+
+func TestServerPathParamsUnescaped(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	route := srv.Handle("DELETE", "/keys/{key}")
+
+	bc := srv.BaseClient(nil)
+	if err := bc.Delete(context.Background(), "/keys/a%2Fb%20c", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := route.LastRequest().PathParams["key"]; got != "a/b c" {
+		t.Log("expected an unescaped path param, got ", got)
+		t.Fail()
+	}
+}
+
+func TestServerErrorReply(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	srv.Handle("", "/missing").
+		ReplyHeader("Content-Type", restclient.MediaTypeProblemJSON).
+		Reply(404, `{"title":"not here"}`)
+
+	err := srv.BaseClient(nil).Delete(context.Background(), "/missing", nil, nil)
+	if !restclient.IsNotFound(err) {
+		t.Log("expected 404, got ", err)
+		t.Fail()
+	}
+}
+
+func TestDecodeQuery(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(t)
+	route := srv.Handle("GET", "/q")
+	err := srv.BaseClient(nil).Get(context.Background(), "/q?uid=x&tags=a,b,c&id=3&id=4", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &testQuery{}
+	if err := route.LastRequest().DecodeQuery(got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UID != "x" || len(got.Tags) != 3 || len(got.IDs) != 2 || got.IDs[1] != 4 {
+		t.Logf("unexpected decoded query %#v", got)
+		t.Fail()
+	}
+}
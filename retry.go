@@ -0,0 +1,151 @@
+package restclient
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// RetryPolicy - controls whether and how failed requests are retried.  Set
+// it on Client.Retry for every request, or override it for a single request
+// with RequestBuilder.Retry.  Request bodies are encoded once and replayed
+// for each attempt.
+type RetryPolicy struct {
+	// MaxAttempts - the total number of attempts, including the first.
+	// Values <= 1 disable retries.
+	MaxAttempts int
+
+	// MinBackoff - the delay before the first retry, doubling for each one
+	// after that, with jitter.  Zero means 100ms.
+	MinBackoff time.Duration
+
+	// MaxBackoff - the cap on the delay between attempts.  Zero means 10s.
+	// A Retry-After header on the failed response takes precedence over
+	// MinBackoff, but is still capped by MaxBackoff.
+	MaxBackoff time.Duration
+
+	// Methods - the http methods that may be retried.  nil means the
+	// idempotent methods GET, HEAD, OPTIONS, TRACE, PUT and DELETE, plus
+	// POST and PATCH for requests carrying an Idempotency-Key header.
+	Methods []string
+
+	// ShouldRetry - decides whether an error is worth retrying.  nil means
+	// IsRetryable.
+	ShouldRetry func(err error) bool
+}
+
+var idempotentMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// retryPolicy - the retry policy in effect for a request, either the
+// per-request one or Client.Retry.
+func (cl *Client) retryPolicy(opts *requestOptions) *RetryPolicy {
+	if opts != nil && opts.retry != nil {
+		return opts.retry
+	}
+	return cl.Retry
+}
+
+// allowsMethod - reports whether requests with the given method may be
+// retried.
+func (rp *RetryPolicy) allowsMethod(method string) bool {
+	methods := rp.Methods
+	if methods == nil {
+		methods = idempotentMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry - decides whether attempt, which failed with err, should be
+// followed by another.
+func (rp *RetryPolicy) shouldRetry(req *http.Request, attempt int, err error) bool {
+	if rp == nil || attempt >= rp.MaxAttempts {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// Can't replay the body.
+		return false
+	}
+	if !rp.allowsMethod(req.Method) &&
+		!(rp.Methods == nil && needsIdempotencyKey(req.Method) && req.Header.Get(IdempotencyKeyHeader) != "") {
+		return false
+	}
+	if rp.ShouldRetry != nil {
+		return rp.ShouldRetry(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff - how long to wait after attempt failed with err.
+func (rp *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	lo, hi := rp.MinBackoff, rp.MaxBackoff
+	if lo <= 0 {
+		lo = defaultMinBackoff
+	}
+	if hi <= 0 {
+		hi = defaultMaxBackoff
+	}
+	var re *ResponseError
+	if errors.As(err, &re) {
+		if d, ok := RetryAfter(re.Header); ok {
+			// Don't let the server stall the client indefinitely.
+			if d > hi {
+				d = hi
+			}
+			return d
+		}
+	}
+	d := lo
+	for i := 1; i < attempt && d < hi; i++ {
+		d *= 2
+	}
+	if d > hi {
+		d = hi
+	}
+	// Equal jitter, so attempts from many clients spread out without ever
+	// collapsing to zero delay.
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// RetryAfter - parses the Retry-After header in h, which is either a number
+// of seconds or an http date.
+func RetryAfter(h http.Header) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
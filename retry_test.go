@@ -0,0 +1,104 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+	out := &testResponse{}
+	err = cl.Put(context.Background(), su, "/thing", nil, &testResponse{Bar: "bar"}, out)
+	if err != nil {
+		t.Fatal("expected success after retries, got ", err)
+	}
+	if calls != 3 || out.Foo != "foo" {
+		t.Logf("expected 3 calls and decoded response, got %d %#v", calls, out)
+		t.Fail()
+	}
+	for _, b := range bodies {
+		if b != `{"Foo":"","Bar":"bar","Baz":0}` {
+			t.Log("body not replayed on retry: ", b)
+			t.Fail()
+		}
+	}
+
+	// POST isn't idempotent, so isn't retried by default.
+	atomic.StoreInt32(&calls, 0)
+	err = cl.Post(context.Background(), su, "/thing", nil, &testResponse{}, nil)
+	var rqe *RequestError
+	if !errors.As(err, &rqe) || calls != 1 || rqe.Attempt != 1 {
+		t.Logf("expected single POST attempt, got %d calls, %v", calls, err)
+		t.Fail()
+	}
+
+	// Out of attempts.
+	atomic.StoreInt32(&calls, -10)
+	cl.Retry.MaxAttempts = 2
+	err = cl.Get(context.Background(), su, "/thing", nil, nil)
+	if !errors.As(err, &rqe) || rqe.Attempt != 2 || StatusCode(err) != 503 {
+		t.Log("expected failure on attempt 2, got ", err)
+		t.Fail()
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	d, ok := RetryAfter(http.Header{"Retry-After": {"3"}})
+	if !ok || d != 3*time.Second {
+		t.Log("unexpected delay-seconds parse ", d, ok)
+		t.Fail()
+	}
+	when := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	d, ok = RetryAfter(http.Header{"Retry-After": {when}})
+	if !ok || d <= 50*time.Second || d > time.Minute {
+		t.Log("unexpected http-date parse ", d, ok)
+		t.Fail()
+	}
+	if _, ok = RetryAfter(http.Header{}); ok {
+		t.Log("expected no delay without header")
+		t.Fail()
+	}
+
+	rp := &RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	for attempt := 1; attempt < 6; attempt++ {
+		if d := rp.backoff(attempt, errors.New("x")); d < 5*time.Millisecond || d > 40*time.Millisecond {
+			t.Logf("attempt %d backoff %s out of range", attempt, d)
+			t.Fail()
+		}
+	}
+	err := &ResponseError{StatusCode: 429, Header: http.Header{"Retry-After": {"2"}}}
+	if d := (&RetryPolicy{MaxBackoff: 5 * time.Second}).backoff(1, err); d != 2*time.Second {
+		t.Log("expected Retry-After to take precedence, got ", d)
+		t.Fail()
+	}
+	if d := rp.backoff(1, err); d != 40*time.Millisecond {
+		t.Log("expected Retry-After to be capped by MaxBackoff, got ", d)
+		t.Fail()
+	}
+}
@@ -0,0 +1,442 @@
+package restclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultSRVMinTTL  = time.Second
+	defaultSRVMaxTTL  = 5 * time.Minute
+	defaultDNSTimeout = 5 * time.Second
+	srvLookupTimeout  = 30 * time.Second
+
+	// srvWeightScale - how much more a target of SRV weight 1 is picked
+	// than one of weight 0.
+	srvWeightScale = 100
+)
+
+// SRVRecord - a single SRV answer.
+type SRVRecord struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	TTL      time.Duration
+}
+
+// SRVResolver - looks up the SRV records for a name such as
+// _api._tcp.example.com.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, name string) ([]SRVRecord, error)
+}
+
+// DNSResolver - an SRVResolver that queries a single DNS server directly,
+// over udp with a tcp retry for truncated answers.  Unlike net.Resolver
+// this reports record TTLs, so SRVEndpoints can refresh exactly when the
+// records expire, but it knows nothing of search domains, resolver options
+// or failing over to another nameserver, so it has to be chosen
+// explicitly.
+type DNSResolver struct {
+	// Server - the host:port of the DNS server.  Empty means the first
+	// nameserver in /etc/resolv.conf.
+	Server string
+
+	// Timeout - the timeout for each query.  Zero means 5s.
+	Timeout time.Duration
+}
+
+// NetResolver - an SRVResolver using net.Resolver, and so the system's
+// resolver configuration.  This is what SRVEndpoints uses by default.
+// net.Resolver doesn't expose TTLs, so every record is given TTL.
+type NetResolver struct {
+	// Resolver - nil means net.DefaultResolver.
+	Resolver *net.Resolver
+
+	// TTL - how long results are used for.  Zero means 30s.
+	TTL time.Duration
+}
+
+// LookupSRV - implements SRVResolver.
+func (nr *NetResolver) LookupSRV(ctx context.Context, name string) ([]SRVRecord, error) {
+	res := nr.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+	ttl := nr.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	_, srvs, err := res.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]SRVRecord, len(srvs))
+	for i, s := range srvs {
+		records[i] = SRVRecord{Target: s.Target, Port: s.Port, Priority: s.Priority, Weight: s.Weight, TTL: ttl}
+	}
+	return records, nil
+}
+
+// LookupSRV - implements SRVResolver.  A name that doesn't exist returns a
+// *net.DNSError with IsNotFound set.
+func (dr *DNSResolver) LookupSRV(ctx context.Context, name string) ([]SRVRecord, error) {
+	server := dr.Server
+	if server == "" {
+		var err error
+		if server, err = systemNameserver(); err != nil {
+			return nil, err
+		}
+	}
+	fail := func(err string, notFound bool) error {
+		return &net.DNSError{Err: err, Name: name, Server: server, IsNotFound: notFound}
+	}
+	fqdn := name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, fail(err.Error(), false)
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fail(err.Error(), false)
+	}
+
+	answer, err := dr.exchange(ctx, "udp", server, packed)
+	if err != nil {
+		return nil, fail(err.Error(), false)
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(answer)
+	if err == nil && h.Truncated {
+		if answer, err = dr.exchange(ctx, "tcp", server, packed); err != nil {
+			return nil, fail(err.Error(), false)
+		}
+		h, err = p.Start(answer)
+	}
+	if err != nil {
+		return nil, fail(err.Error(), false)
+	}
+	if h.ID != id {
+		return nil, fail("answer id does not match query", false)
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, fail("no such host", true)
+	default:
+		return nil, fail("server answered "+h.RCode.String(), false)
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, fail(err.Error(), false)
+	}
+
+	var records []SRVRecord
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, fail(err.Error(), false)
+		}
+		if ah.Type != dnsmessage.TypeSRV {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, fail(err.Error(), false)
+			}
+			continue
+		}
+		srv, err := p.SRVResource()
+		if err != nil {
+			return nil, fail(err.Error(), false)
+		}
+		records = append(records, SRVRecord{
+			Target:   srv.Target.String(),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			TTL:      time.Duration(ah.TTL) * time.Second,
+		})
+	}
+	if len(records) == 0 {
+		return nil, fail("no SRV records", true)
+	}
+	return records, nil
+}
+
+// exchange - sends one query and reads the answer.  Over tcp messages are
+// prefixed with their length.
+func (dr *DNSResolver) exchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	timeout := dr.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err = conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// systemNameserver - the first nameserver in /etc/resolv.conf.
+func systemNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("restclient: no DNS server configured: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", errors.New("restclient: no nameserver in /etc/resolv.conf")
+}
+
+// SRVEndpoints - an EndpointSource backed by DNS SRV records.  Each record
+// becomes an endpoint at Scheme://target:port/Path, carrying the record's
+// priority and weight, so use it with the PriorityFailover strategy.
+// Records are looked up again once the lowest TTL among them has expired;
+// if that fails, the previous endpoints keep being used and the lookup is
+// retried after MinTTL.
+type SRVEndpoints struct {
+	// Name - the SRV name to look up, e.g. _api._tcp.example.com.
+	Name string
+
+	// Scheme - the URL scheme of the endpoints.  Empty means https.
+	Scheme string
+
+	// Path - the base path of the endpoints, e.g. /v1.
+	Path string
+
+	// Resolver - nil means a NetResolver using net.DefaultResolver.
+	Resolver SRVResolver
+
+	// MinTTL, MaxTTL - bounds on how long a lookup is used for,
+	// whatever the records say.  Zero means 1s and 5m.
+	MinTTL, MaxTTL time.Duration
+
+	mu         sync.Mutex
+	endpoints  []Endpoint
+	expires    time.Time
+	lastErr    error
+	refreshing chan struct{}
+}
+
+// Endpoints - implements EndpointSource.  Once the cached endpoints
+// expire they are still returned while a lookup runs in the background,
+// and kept if it fails.  Only the very first callers wait for a lookup,
+// and they share it.  Until one succeeds, a failed lookup is not retried
+// for MinTTL, with its error returned in the meantime.
+func (se *SRVEndpoints) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	se.mu.Lock()
+	if se.endpoints != nil {
+		if !time.Now().Before(se.expires) && se.refreshing == nil {
+			se.refresh()
+		}
+		eps := se.endpoints
+		se.mu.Unlock()
+		return eps, nil
+	}
+	done := se.refreshing
+	if done == nil {
+		if se.lastErr != nil && time.Now().Before(se.expires) {
+			err := se.lastErr
+			se.mu.Unlock()
+			return nil, err
+		}
+		done = se.refresh()
+	}
+	se.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	if se.endpoints == nil {
+		return nil, se.lastErr
+	}
+	return se.endpoints, nil
+}
+
+// refresh - starts a lookup in the background, returning a channel closed
+// when it is done.  se.mu is held.  The lookup isn't tied to any caller's
+// context, since others may be waiting on it.
+func (se *SRVEndpoints) refresh() chan struct{} {
+	done := make(chan struct{})
+	se.refreshing = done
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+		eps, ttl, err := se.lookup(ctx)
+		cancel()
+		se.mu.Lock()
+		defer se.mu.Unlock()
+		se.refreshing = nil
+		if err != nil {
+			se.lastErr = err
+			se.expires = time.Now().Add(se.clampTTL(0))
+			return
+		}
+		se.endpoints, se.lastErr = eps, nil
+		se.expires = time.Now().Add(se.clampTTL(ttl))
+	}()
+	return done
+}
+
+// Refresh - discards the cached endpoints' expiry, so the next request
+// starts a lookup.
+func (se *SRVEndpoints) Refresh() {
+	se.mu.Lock()
+	se.expires = time.Time{}
+	se.mu.Unlock()
+}
+
+func (se *SRVEndpoints) lookup(ctx context.Context) ([]Endpoint, time.Duration, error) {
+	res := se.Resolver
+	if res == nil {
+		res = &NetResolver{}
+	}
+	records, err := res.LookupSRV(ctx, se.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	scheme := se.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	var (
+		eps []Endpoint
+		ttl time.Duration
+	)
+	for i, r := range records {
+		if i == 0 || r.TTL < ttl {
+			ttl = r.TTL
+		}
+		target := strings.TrimSuffix(r.Target, ".")
+		if target == "" {
+			// A target of "." means the service is deliberately not
+			// available.
+			continue
+		}
+		eps = append(eps, Endpoint{
+			URL: &url.URL{
+				Scheme: scheme,
+				Host:   net.JoinHostPort(target, strconv.Itoa(int(r.Port))),
+				Path:   se.Path,
+			},
+			Priority: int(r.Priority),
+			Weight:   srvWeight(r.Weight),
+		})
+	}
+	if len(eps) == 0 {
+		return nil, 0, &net.DNSError{Err: "service not available", Name: se.Name, IsNotFound: true}
+	}
+	return eps, ttl, nil
+}
+
+// srvWeight - maps an SRV weight to an Endpoint weight.  RFC 2782 gives
+// targets of weight 0 a very small chance of selection when others have
+// weight, rather than the equal share a zero Endpoint.Weight gets, so
+// weights are scaled up to leave room for them below 1.  When every target
+// has weight 0 they still share equally.
+func srvWeight(w uint16) int {
+	if w == 0 {
+		return 1
+	}
+	return int(w) * srvWeightScale
+}
+
+func (se *SRVEndpoints) clampTTL(ttl time.Duration) time.Duration {
+	lo, hi := se.MinTTL, se.MaxTTL
+	if lo <= 0 {
+		lo = defaultSRVMinTTL
+	}
+	if hi <= 0 {
+		hi = defaultSRVMaxTTL
+	}
+	if ttl < lo {
+		return lo
+	}
+	if ttl > hi {
+		return hi
+	}
+	return ttl
+}
+
+// NewSRVClient - create a MultiClient whose endpoints come from the SRV
+// records for name, using the PriorityFailover strategy.
+func NewSRVClient(name, scheme string, cfg *ClientConfig, transport http.RoundTripper) (*MultiClient, error) {
+	client, err := NewClient(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiClient{
+		Client:   client,
+		Source:   &SRVEndpoints{Name: name, Scheme: scheme},
+		Strategy: PriorityFailover,
+	}, nil
+}
@@ -0,0 +1,267 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS - a udp DNS server answering SRV queries from records.
+type fakeDNS struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]SRVRecord
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &fakeDNS{conn: conn, records: make(map[string][]SRVRecord)}
+	go fd.serve()
+	t.Cleanup(func() { conn.Close() })
+	return fd
+}
+
+func (fd *fakeDNS) set(name string, records ...SRVRecord) {
+	fd.mu.Lock()
+	fd.records[name] = records
+	fd.mu.Unlock()
+}
+
+func (fd *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := fd.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		fd.mu.Lock()
+		records, ok := fd.records[q.Name.String()]
+		fd.mu.Unlock()
+
+		rh := dnsmessage.Header{ID: h.ID, Response: true, RCode: dnsmessage.RCodeSuccess}
+		if !ok {
+			rh.RCode = dnsmessage.RCodeNameError
+		}
+		b := dnsmessage.NewBuilder(nil, rh)
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		for _, r := range records {
+			b.SRVResource(
+				dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: uint32(r.TTL / time.Second)},
+				dnsmessage.SRVResource{
+					Priority: r.Priority,
+					Weight:   r.Weight,
+					Port:     r.Port,
+					Target:   dnsmessage.MustNewName(r.Target),
+				},
+			)
+		}
+		msg, err := b.Finish()
+		if err != nil {
+			continue
+		}
+		fd.conn.WriteTo(msg, addr)
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+	fd := newFakeDNS(t)
+	fd.set("_api._tcp.example.com.",
+		SRVRecord{Target: "a.example.com.", Port: 8443, Priority: 10, Weight: 60, TTL: 30 * time.Second},
+		SRVRecord{Target: "b.example.com.", Port: 8443, Priority: 20, Weight: 0, TTL: 20 * time.Second},
+	)
+	dr := &DNSResolver{Server: fd.conn.LocalAddr().String()}
+
+	records, err := dr.LookupSRV(context.Background(), "_api._tcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0] != (SRVRecord{Target: "a.example.com.", Port: 8443, Priority: 10, Weight: 60, TTL: 30 * time.Second}) {
+		t.Logf("unexpected records %#v", records)
+		t.Fail()
+	}
+
+	_, err = dr.LookupSRV(context.Background(), "_missing._tcp.example.com")
+	var de *net.DNSError
+	if !errors.As(err, &de) || !de.IsNotFound {
+		t.Log("expected not found DNSError, got ", err)
+		t.Fail()
+	}
+
+	se := &SRVEndpoints{Name: "_api._tcp.example.com", Path: "/v1", Resolver: dr}
+	eps, err := se.Endpoints(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 || eps[0].URL.String() != "https://a.example.com:8443/v1" || eps[0].Priority != 10 ||
+		eps[0].Weight != 60*srvWeightScale || eps[1].Weight != 1 {
+		t.Logf("unexpected endpoints %v", eps)
+		t.Fail()
+	}
+	if ttl := time.Until(se.expires); ttl <= 10*time.Second || ttl > 20*time.Second {
+		t.Log("expected the lowest record TTL to be used, got ", ttl)
+		t.Fail()
+	}
+}
+
+// fakeSRVResolver - answers from a fixed list, or fails.
+type fakeSRVResolver struct {
+	mu      sync.Mutex
+	records []SRVRecord
+	err     error
+	lookups int
+
+	// block - when set, lookups wait for it to be closed.
+	block chan struct{}
+}
+
+func (fr *fakeSRVResolver) LookupSRV(ctx context.Context, name string) ([]SRVRecord, error) {
+	fr.mu.Lock()
+	fr.lookups++
+	block := fr.block
+	fr.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.records, fr.err
+}
+
+func (fr *fakeSRVResolver) count() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.lookups
+}
+
+func TestSRVEndpointsRefresh(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Foo":"` + r.URL.Path + `"}`))
+	}))
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(su.Port())
+
+	fr := &fakeSRVResolver{records: []SRVRecord{{Target: "127.0.0.1.", Port: uint16(port), TTL: 0}}}
+	mc, err := NewSRVClient("_api._tcp.example.com", "http", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se := mc.Source.(*SRVEndpoints)
+	se.Resolver = fr
+	se.MinTTL = 20 * time.Millisecond
+
+	out := &testResponse{}
+	if err := mc.Get(context.Background(), "/things", nil, out); err != nil || out.Foo != "/things" {
+		t.Fatalf("expected request to the SRV target, got %v %v", out, err)
+	}
+	mc.Get(context.Background(), "/things", nil, nil)
+	if fr.count() != 1 {
+		t.Log("expected cached lookup within the TTL, got ", fr.lookups)
+		t.Fail()
+	}
+
+	// Once expired, a failed lookup keeps the previous endpoints.
+	time.Sleep(30 * time.Millisecond)
+	fr.mu.Lock()
+	fr.err = errors.New("dns down")
+	fr.mu.Unlock()
+	if err := mc.Get(context.Background(), "/things", nil, nil); err != nil {
+		t.Log("expected stale endpoints to be used, got ", err)
+		t.Fail()
+	}
+	waitFor(t, func() bool { return fr.count() == 2 })
+}
+
+func TestSRVEndpointsStaleWhileRefreshing(t *testing.T) {
+	fr := &fakeSRVResolver{records: []SRVRecord{{Target: "a.example.com.", Port: 443}}, block: make(chan struct{})}
+	se := &SRVEndpoints{Name: "_api._tcp.example.com", Resolver: fr, MinTTL: 10 * time.Millisecond}
+
+	// The first callers share one lookup.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if eps, err := se.Endpoints(context.Background()); err != nil || len(eps) != 1 {
+				t.Log("unexpected first lookup ", eps, err)
+				t.Fail()
+			}
+		}()
+	}
+	waitFor(t, func() bool { return fr.count() == 1 })
+	close(fr.block)
+	wg.Wait()
+
+	// Once expired, a slow lookup doesn't hold anyone up.
+	fr.mu.Lock()
+	fr.block = make(chan struct{})
+	fr.records = []SRVRecord{{Target: "b.example.com.", Port: 443}}
+	fr.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		eps, err := se.Endpoints(context.Background())
+		if err != nil || eps[0].URL.Host != "a.example.com:443" {
+			t.Log("expected the stale endpoints, got ", eps, err)
+			t.Fail()
+		}
+	}
+	waitFor(t, func() bool { return fr.count() == 2 })
+	se.Endpoints(context.Background())
+	if fr.count() != 2 {
+		t.Log("expected a single background lookup, got ", fr.count())
+		t.Fail()
+	}
+	close(fr.block)
+	waitFor(t, func() bool {
+		eps, _ := se.Endpoints(context.Background())
+		return eps[0].URL.Host == "b.example.com:443"
+	})
+}
+
+func TestSRVEndpointsFailureBackoff(t *testing.T) {
+	fr := &fakeSRVResolver{err: errors.New("no answer")}
+	se := &SRVEndpoints{Name: "_api._tcp.example.com", Resolver: fr, MinTTL: 50 * time.Millisecond}
+
+	for i := 0; i < 3; i++ {
+		if _, err := se.Endpoints(context.Background()); err == nil || err.Error() != "no answer" {
+			t.Log("expected the lookup error, got ", err)
+			t.Fail()
+		}
+	}
+	if fr.count() != 1 {
+		t.Log("expected failed lookups to back off, got ", fr.count())
+		t.Fail()
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fr.mu.Lock()
+	fr.err, fr.records = nil, []SRVRecord{{Target: "a.example.com.", Port: 443}}
+	fr.mu.Unlock()
+	if eps, err := se.Endpoints(context.Background()); err != nil || len(eps) != 1 || fr.count() != 2 {
+		t.Log("expected a new lookup after MinTTL, got ", eps, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,70 @@
+package restclient
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// JoinURL - resolves the path argument of a request against baseURL.  This
+// is how every request URL is built, and the rules are:
+//
+//   - If path is an absolute URL with a scheme and host, for instance
+//     "https://other.example.com/x?y=1", it is used as is and baseURL is
+//     ignored entirely, including its query.
+//   - Otherwise path is appended to the path of baseURL with exactly one
+//     slash between them, like url.JoinPath.  Leading slashes on path do not
+//     make it relative to the host root: "/users" and "users" both land
+//     under the base path.  A trailing slash on path is kept, and an empty
+//     path leaves the base path untouched.  Dot segments are not resolved.
+//   - Percent-encoded sequences in both the base path and path, such as
+//     %2F, are preserved exactly as given.
+//   - The query of baseURL comes first, followed by any query string in
+//     path.  Both are kept in their raw form, so flag style parameters like
+//     "?caps" survive.  ReqWithHeaders then appends the encoded query struct.
+//   - Fragments are dropped, since they are never sent to the server.
+func JoinURL(baseURL *url.URL, path string) (*url.URL, error) {
+	if ref, err := url.Parse(path); err == nil && ref.Scheme != "" && ref.Host != "" {
+		ref.Fragment = ""
+		ref.RawFragment = ""
+		return ref, nil
+	}
+	if baseURL == nil {
+		return nil, errors.New("relative path requires a base URL")
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(path, "?")
+	if i := strings.IndexByte(rawPath, '#'); i >= 0 {
+		rawPath, rawQuery = rawPath[:i], ""
+	} else if i := strings.IndexByte(rawQuery, '#'); i >= 0 {
+		rawQuery = rawQuery[:i]
+	}
+
+	u := *baseURL
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if rawPath = strings.TrimLeft(rawPath, "/"); rawPath != "" {
+		escaped := strings.TrimRight(baseURL.EscapedPath(), "/") + "/" + rawPath
+		unescaped, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = unescaped
+		u.RawPath = escaped
+	}
+	u.RawQuery = joinRawQuery(u.RawQuery, rawQuery)
+	u.ForceQuery = false
+	return &u, nil
+}
+
+// joinRawQuery - joins raw query strings with "&", skipping empty ones.
+func joinRawQuery(queries ...string) string {
+	var parts []string
+	for _, q := range queries {
+		if q != "" {
+			parts = append(parts, q)
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,78 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"http://h", "users", "http://h/users"},
+		{"http://h", "", "http://h"},
+		{"http://h/", "", "http://h/"},
+		{"http://h/api", "/users", "http://h/api/users"},
+		{"http://h/api/", "/users", "http://h/api/users"},
+		{"http://h/api/", "users/", "http://h/api/users/"},
+		{"http://h/api//", "//users", "http://h/api/users"},
+		{"http://h/api?v=2", "users", "http://h/api/users?v=2"},
+		{"http://h/api?v=2", "users?limit=5", "http://h/api/users?v=2&limit=5"},
+		{"http://h/admin", "/user?caps", "http://h/admin/user?caps"},
+		{"http://h/api#frag", "users#top", "http://h/api/users"},
+		{"http://h/api", "users#top?notquery", "http://h/api/users"},
+		{"http://h/a%2Fb", "c%2Fd/e", "http://h/a%2Fb/c%2Fd/e"},
+		{"http://h/api", "?only=query", "http://h/api?only=query"},
+		{"http://h/api?v=2", "https://other.example.com/x?y=1#f", "https://other.example.com/x?y=1"},
+		{"http://h/api", "weird:segment", "http://h/api/weird:segment"},
+	}
+	for _, tt := range tests {
+		bu, err := url.Parse(tt.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := JoinURL(bu, tt.path)
+		if err != nil {
+			t.Logf("JoinURL(%q, %q) failed: %s", tt.base, tt.path, err)
+			t.Fail()
+			continue
+		}
+		if got.String() != tt.want {
+			t.Logf("JoinURL(%q, %q) = %q, want %q", tt.base, tt.path, got, tt.want)
+			t.Fail()
+		}
+		if bu.String() != tt.base {
+			t.Logf("JoinURL modified base URL %q to %q", tt.base, bu)
+			t.Fail()
+		}
+	}
+
+	if _, err := JoinURL(nil, "/users"); err == nil {
+		t.Log("expected error joining relative path without base URL")
+		t.Fail()
+	}
+}
+
+func TestRequestURLQueryMerge(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+	}))
+	defer srv.Close()
+	bu, _ := url.Parse(srv.URL + "/api/?v=2")
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Get(context.Background(), bu, "/user?caps", &testValidatorRequest{UID: "59"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/api/user?v=2&caps&uid=59"; got != want {
+		t.Logf("expected request URI %s, got %s", want, got)
+		t.Fail()
+	}
+}
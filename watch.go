@@ -0,0 +1,157 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIndexHeader     = "X-Consul-Index"
+	defaultWatchWait       = 5 * time.Minute
+	defaultWatchMinBackoff = time.Second
+	defaultWatchMaxBackoff = time.Minute
+)
+
+// WatchOptions - controls BaseClient.Watch.  The zero value speaks Consul's
+// blocking query protocol.
+type WatchOptions struct {
+	// IndexHeader - the response header carrying the index.  Empty means
+	// X-Consul-Index.
+	IndexHeader string
+
+	// IndexParam, WaitParam - the query parameters the last seen index and
+	// the wait time are sent in.  Empty means index and wait.
+	IndexParam, WaitParam string
+
+	// Wait - how long the server may hold each request waiting for a
+	// change.  Zero means 5m.  When Client.Client has a timeout, the wait
+	// is capped at seven eighths of it, leaving room for the jitter
+	// servers such as Consul add and for the response itself.
+	Wait time.Duration
+
+	// MinBackoff, MaxBackoff - the delay after an error, doubling for each
+	// consecutive one, with jitter.  Zero means 1s and 1m.  A Retry-After
+	// header takes precedence, up to the maximum.
+	MinBackoff, MaxBackoff time.Duration
+}
+
+// WatchEvent - a change, or an error, delivered by BaseClient.Watch.
+type WatchEvent struct {
+	// Index - the index of the change.
+	Index uint64
+
+	// Value - the result of newValue, with the response decoded into it.
+	Value interface{}
+
+	// Err - set when a request failed.  The watch carries on after a
+	// backoff.
+	Err error
+}
+
+// Watch - follows the resource at path with blocking queries until ctx is
+// done, when the returned channel is closed.  Each GET carries the last
+// index seen, so the server holds it until the resource changes or the
+// wait time passes, and only responses with a new index are delivered,
+// decoded into a fresh value from newValue, or not decoded if newValue is
+// nil.  As in Consul, an index going backwards restarts from zero, and an
+// index of zero is treated as one.
+func (bc *BaseClient) Watch(ctx context.Context, path string, queryStruct interface{},
+	newValue func() interface{}, opts *WatchOptions) <-chan WatchEvent {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	ch := make(chan WatchEvent)
+	go bc.watch(ctx, path, queryStruct, newValue, opts, ch)
+	return ch
+}
+
+func (bc *BaseClient) watch(ctx context.Context, path string, queryStruct interface{},
+	newValue func() interface{}, opts *WatchOptions, ch chan<- WatchEvent) {
+	defer close(ch)
+	header := opts.IndexHeader
+	if header == "" {
+		header = defaultIndexHeader
+	}
+	indexParam, waitParam := opts.IndexParam, opts.WaitParam
+	if indexParam == "" {
+		indexParam = "index"
+	}
+	if waitParam == "" {
+		waitParam = "wait"
+	}
+	wait := opts.Wait
+	if wait <= 0 {
+		wait = defaultWatchWait
+	}
+	if t := bc.Client.Client.Timeout; t > 0 && wait > t-t/8 {
+		wait = t - t/8
+	}
+	backoff := &RetryPolicy{MinBackoff: opts.MinBackoff, MaxBackoff: opts.MaxBackoff}
+	if backoff.MinBackoff <= 0 {
+		backoff.MinBackoff = defaultWatchMinBackoff
+	}
+	if backoff.MaxBackoff <= 0 {
+		backoff.MaxBackoff = defaultWatchMaxBackoff
+	}
+	send := func(ev WatchEvent) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(path, "?")
+	var last, next uint64
+	failures := 0
+	for ctx.Err() == nil {
+		p := rawPath + "?" + joinRawQuery(rawQuery, url.Values{
+			indexParam: {strconv.FormatUint(next, 10)},
+			waitParam:  {wait.String()},
+		}.Encode())
+
+		var value interface{}
+		if newValue != nil {
+			value = newValue()
+		}
+		resp, err := bc.ReqWithHeaders(ctx, "GET", p, queryStruct, nil, value, nil)
+		var index uint64
+		if err == nil {
+			index, err = strconv.ParseUint(resp.Header.Get(header), 10, 64)
+			if err != nil {
+				err = bc.Client.requestError("GET", resp.Request.URL.String(), "", 1, PhaseDecode,
+					fmt.Errorf("bad %s header %q: %w", header, resp.Header.Get(header), err))
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			if !send(WatchEvent{Err: err}) || sleepContext(ctx, backoff.backoff(failures, err)) != nil {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		if index == 0 {
+			index = 1
+		}
+		if index != last && !send(WatchEvent{Index: index, Value: value}) {
+			return
+		}
+		if index < last {
+			// The server's index was reset, so start over.
+			next = 0
+		} else {
+			next = index
+		}
+		last = index
+	}
+}
@@ -0,0 +1,168 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// consulServer - a resource served with Consul style blocking queries.
+type consulServer struct {
+	mu      sync.Mutex
+	index   uint64
+	value   string
+	fail    int
+	changed chan struct{}
+	queries []string
+}
+
+func (cs *consulServer) set(index uint64, value string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.index, cs.value = index, value
+	close(cs.changed)
+	cs.changed = make(chan struct{})
+}
+
+func (cs *consulServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.mu.Lock()
+	cs.queries = append(cs.queries, r.URL.RawQuery)
+	if cs.fail > 0 {
+		cs.fail--
+		cs.mu.Unlock()
+		w.WriteHeader(500)
+		return
+	}
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+	changed := cs.changed
+	if index > 0 && index >= cs.index {
+		cs.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		cs.mu.Lock()
+	}
+	index, value := cs.index, cs.value
+	cs.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"foo":%q}`, value)
+}
+
+func TestWatch(t *testing.T) {
+	cs := &consulServer{index: 5, value: "a", fail: 1, changed: make(chan struct{})}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := bc.Watch(ctx, "/v1/kv/key", &struct {
+		DC string `url:"dc"`
+	}{"dc1"}, func() interface{} { return &testResponse{} },
+		&WatchOptions{Wait: 20 * time.Millisecond, MinBackoff: time.Millisecond})
+
+	next := func() WatchEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		return WatchEvent{}
+	}
+	expect := func(index uint64, foo string) {
+		t.Helper()
+		ev := next()
+		if ev.Err != nil || ev.Index != index || ev.Value.(*testResponse).Foo != foo {
+			t.Logf("expected %d %s, got %#v", index, foo, ev)
+			t.Fail()
+		}
+	}
+
+	if ev := next(); ev.Err == nil {
+		t.Log("expected the error to be delivered")
+		t.Fail()
+	}
+	expect(5, "a")
+	// Let a few waits time out without a change.
+	time.Sleep(70 * time.Millisecond)
+	cs.set(7, "b")
+	expect(7, "b")
+	cs.set(2, "reset")
+	expect(2, "reset")
+	cs.set(3, "c")
+	expect(3, "c")
+
+	cancel()
+	for range events {
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.queries[0] != "index=0&wait=20ms&dc=dc1" {
+		t.Log("unexpected first query ", cs.queries[0])
+		t.Fail()
+	}
+	reset := false
+	for i, q := range cs.queries[1:] {
+		if q == "index=0&wait=20ms&dc=dc1" && i > 1 {
+			reset = true
+		}
+	}
+	if !reset || len(cs.queries) < 6 {
+		t.Log("expected a query restarting from zero after the reset ", cs.queries)
+		t.Fail()
+	}
+}
+
+func TestWatchWaitCapped(t *testing.T) {
+	cs := &consulServer{index: 5, value: "a", changed: make(chan struct{})}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+	su, _ := url.Parse(srv.URL)
+	cl, err := NewClient(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Client.Timeout = 80 * time.Millisecond
+	bc := &BaseClient{Client: cl, BaseURL: su}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := bc.Watch(ctx, "/v1/kv/key?recurse=true", nil, nil, nil)
+	for i := 0; i < 2; i++ {
+		if ev := <-events; ev.Err != nil || ev.Index != 5+uint64(i) {
+			t.Logf("unexpected event %#v", ev)
+			t.Fail()
+		}
+		if i == 0 {
+			// Outlive a wait, which must end before the client timeout.
+			time.Sleep(150 * time.Millisecond)
+			cs.set(6, "b")
+		}
+	}
+	cancel()
+	for range events {
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.queries[0] != "recurse=true&index=0&wait=70ms" {
+		t.Log("unexpected first query ", cs.queries[0])
+		t.Fail()
+	}
+}